@@ -1,6 +1,8 @@
 #!/bin/bash
 
-#This script cleans the certs secret generated when installed and the mutation web hook configuration
+#This script cleans the certs secret and the anchors generated when installed and the mutating and validating web hook configurations
 
-kubectl -n kube-system delete secret kubernetes-webhook-injector-certs
-kubectl delete mutatingwebhookconfigurations.admissionregistration.k8s.io kubernetes-webhook-injector
\ No newline at end of file
+kubectl -n kube-system delete secret kubernetes-faketime-injector-certs
+kubectl -n kube-system delete configmap kubernetes-faketime-injector-anchors
+kubectl delete mutatingwebhookconfigurations.admissionregistration.k8s.io kubernetes-faketime-injector
+kubectl delete validatingwebhookconfigurations.admissionregistration.k8s.io kubernetes-faketime-injector
@@ -1,17 +1,38 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
 	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		preview()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validate()
+		return
+	}
+
 	var wo *webhook.WebHookOptions
 	var err error
 	if wo, err = webhook.NewWebHookOptions(); err != nil {
 		log.Fatalf("Please input valid params. %v", err)
 	}
+	if wo.Cleanup {
+		if err = webhook.Cleanup(wo); err != nil {
+			log.Fatalf("Failed to clean up: %v", err)
+		}
+		return
+	}
 
 	ws, err := webhook.NewWebHookServer(wo)
 
@@ -20,7 +41,84 @@ func main() {
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc(webhook.MutatingWebhookConfigurationPath, ws.Serve)
+	mux.HandleFunc(webhook.ValidatingWebhookConfigurationPath, ws.Serve)
+	mux.HandleFunc(webhook.AuthorizationPath, ws.Serve)
 	ws.Server.Handler = mux
 
-	log.Fatal(ws.Run())
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle(webhook.MetricsPath, metrics.Handler())
+	ws.MetricsServer.Handler = metricsMux
+
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc(webhook.HealthzPath, ws.Healthz)
+	healthMux.HandleFunc(webhook.ReadyzPath, ws.Readyz)
+	ws.HealthServer.Handler = healthMux
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err := ws.Run(ctx); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// preview prints the patch and the mutated manifest without a cluster, e.g. fake-time-injector preview -f pod.yaml
+func preview() {
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	file := flag.String("f", "-", "The manifest to preview, - reads stdin.")
+	wo := webhook.ParseWebHookOptions()
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := webhook.Preview(wo, in, os.Stdout); err != nil {
+		log.Fatalf("Failed to preview %s: %v", *file, err)
+	}
+}
+
+// validate checks the annotations of manifests for CI pipelines, e.g. kustomize build | fake-time-injector validate -o json -
+func validate() {
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	output := flag.String("o", "text", "The output format, text or json.")
+	strict := flag.Bool("strict", false, "Fail on warnings, e.g. unknown annotations, as well as on errors.")
+	webhook.ParseWebHookOptions()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var issues []webhook.ManifestIssue
+	for _, file := range files {
+		fileIssues, err := validateFile(file)
+		if err != nil {
+			log.Fatalf("Failed to validate %s: %v", file, err)
+		}
+		issues = append(issues, fileIssues...)
+	}
+
+	report := webhook.NewValidationReport(issues)
+	if err := report.Write(os.Stdout, *output); err != nil {
+		log.Fatal(err)
+	}
+	if report.Errors > 0 || (*strict && report.Warnings > 0) {
+		os.Exit(1)
+	}
+}
+
+// validateFile validates the manifests of the file, - for stdin, which is left open
+func validateFile(file string) ([]webhook.ManifestIssue, error) {
+	if file == "-" {
+		return webhook.ValidateManifest(file, os.Stdin)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return webhook.ValidateManifest(file, f)
 }
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is an admission decision of the injector
+type Record struct {
+	Time       time.Time `json:"time"`
+	RequestUID string    `json:"requestUID"`
+	Webhook    string    `json:"webhook"`
+	Operation  string    `json:"operation"`
+	User       string    `json:"user"`
+	Groups     []string  `json:"groups,omitempty"`
+	Namespace  string    `json:"namespace"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	// the controller of the pod, e.g. ReplicaSet/web-5d9c7
+	Owner           string   `json:"owner,omitempty"`
+	FakeTime        string   `json:"fakeTime,omitempty"`
+	Mode            string   `json:"mode,omitempty"`
+	EffectiveOffset string   `json:"effectiveOffset,omitempty"`
+	AnchorGroup     string   `json:"anchorGroup,omitempty"`
+	Outcome         string   `json:"outcome"`
+	Messages        []string `json:"messages,omitempty"`
+}
+
+// Logger writes the records as JSON lines to stdout or to a file rotated by size
+type Logger struct {
+	mu         sync.Mutex
+	out        io.Writer
+	file       *os.File
+	path       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+}
+
+// NewLogger writes to stdout if path is "-", to a file rotated after maxSizeMB otherwise,
+// the file is renamed to path.1 and the older ones up to path.<maxBackups> are shifted
+func NewLogger(path string, maxSizeMB int, maxBackups int) (*Logger, error) {
+	if path == "-" {
+		return &Logger{out: os.Stdout}, nil
+	}
+	l := &Logger{path: path, maxSize: int64(maxSizeMB) * 1024 * 1024, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %v", l.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log %s: %v", l.path, err)
+	}
+	l.file = file
+	l.out = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups and reopens an empty file
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if l.maxBackups > 0 {
+		for i := l.maxBackups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", l.path, i)
+			if _, err := os.Stat(from); err == nil {
+				if err := os.Rename(from, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Log writes the record, a nil logger discards it
+func (l *Logger) Log(record *Record) error {
+	if l == nil {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log %s: %v", l.path, err)
+		}
+	}
+	n, err := l.out.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Close closes the file of the logger
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
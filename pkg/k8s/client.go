@@ -1,13 +1,15 @@
 package k8s
 
 import (
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	log "k8s.io/klog"
 )
 
 var (
-	clientSet kubernetes.Interface
+	clientSet     kubernetes.Interface
+	dynamicClient dynamic.Interface
 )
 
 func InitClientSetOrDie(masterUrl, kubeConfigPath string) {
@@ -21,6 +23,12 @@ func InitClientSetOrDie(masterUrl, kubeConfigPath string) {
 		log.Fatal(err)
 	}
 	clientSet = cs
+
+	dc, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamicClient = dc
 }
 func GetClientSet() kubernetes.Interface {
 	if clientSet == nil {
@@ -28,3 +36,11 @@ func GetClientSet() kubernetes.Interface {
 	}
 	return clientSet
 }
+
+// GetDynamicClient returns the client of the resources without typed clients, e.g. the workloads of OpenKruise
+func GetDynamicClient() dynamic.Interface {
+	if dynamicClient == nil {
+		log.Fatal("Call InitClientSetOrDie to initialize dynamicClient first")
+	}
+	return dynamicClient
+}
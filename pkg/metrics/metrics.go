@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const (
+	namespace = "fake_time_injector"
+
+	OutcomePatched = "patched"
+	OutcomeAllowed = "allowed"
+	OutcomeError   = "error"
+	OutcomeDenied  = "denied"
+
+	PluginMatched  = "matched"
+	PluginInjected = "injected"
+	PluginSkipped  = "skipped"
+	PluginInvalid  = "invalid"
+	// the change of a running pod can not be applied in place
+	PluginRestartRequired = "restart_required"
+	// the pod violates the safety policy
+	PluginPolicyViolation = "policy_violation"
+	PluginPolicyWarning   = "policy_warning"
+)
+
+var (
+	// AdmissionRequests counts admission requests by operation and outcome
+	AdmissionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_requests_total",
+		Help:      "Number of admission requests by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	// PatchDuration observes how long the plugins take to generate the patches
+	PatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "patch_duration_seconds",
+		Help:      "Latency of patch generation in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	// PluginEvents counts the matched, injected, skipped, invalid and restart required pods of every plugin
+	PluginEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_events_total",
+		Help:      "Number of pods matched, injected, skipped or rejected as invalid by plugin.",
+	}, []string{"plugin", "event"})
+
+	// ClusterModeAnchors is the number of anchor groups sharing a fake time in cluster mode
+	ClusterModeAnchors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_mode_anchors",
+		Help:      "Number of active anchor groups in cluster mode.",
+	})
+
+	// CertificateExpiry is the expiry time of the serving certificate
+	CertificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Expiry time of the serving certificate in unix seconds.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(AdmissionRequests, PatchDuration, PluginEvents, ClusterModeAnchors, CertificateExpiry)
+}
+
+// Handler returns the http handler exposing the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
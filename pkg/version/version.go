@@ -0,0 +1,5 @@
+package version
+
+// Version of the injector, it is set at build time by
+// -ldflags "-X github.com/CloudNativeGame/fake-time-injector/pkg/version.Version=..."
+var Version = "dev"
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	addmissionV1 "k8s.io/api/admission/v1"
+	addmissionV1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// decodeAdmissionReview decodes an admission.k8s.io/v1 or v1beta1 AdmissionReview.
+// v1beta1 reviews are converted to v1, the api version of the request is returned to reply in the same version.
+func decodeAdmissionReview(body []byte) (*addmissionV1.AdmissionReview, string, error) {
+	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	switch review := obj.(type) {
+	case *addmissionV1.AdmissionReview:
+		return review, addmissionV1.SchemeGroupVersion.String(), nil
+	case *addmissionV1beta1.AdmissionReview:
+		ar := &addmissionV1.AdmissionReview{}
+		if review.Request != nil {
+			// the request of v1beta1 has the same fields as v1
+			ar.Request = &addmissionV1.AdmissionRequest{}
+			if err := convertAdmission(review.Request, ar.Request); err != nil {
+				return nil, "", err
+			}
+		}
+		return ar, addmissionV1beta1.SchemeGroupVersion.String(), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported admission review %v", gvk)
+	}
+}
+
+// encodeAdmissionReview wraps the response in an AdmissionReview of the given api version
+func encodeAdmissionReview(apiVersion string, response *addmissionV1.AdmissionResponse) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{
+		Kind:       "AdmissionReview",
+		APIVersion: apiVersion,
+	}
+	if apiVersion != addmissionV1beta1.SchemeGroupVersion.String() {
+		typeMeta.APIVersion = addmissionV1.SchemeGroupVersion.String()
+		return json.Marshal(addmissionV1.AdmissionReview{TypeMeta: typeMeta, Response: response})
+	}
+
+	review := addmissionV1beta1.AdmissionReview{TypeMeta: typeMeta}
+	if response != nil {
+		review.Response = &addmissionV1beta1.AdmissionResponse{}
+		if err := convertAdmission(response, review.Response); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(review)
+}
+
+// convertAdmission converts between the v1 and v1beta1 admission types, which share the same json schema
+func convertAdmission(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
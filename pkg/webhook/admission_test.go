@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/audit"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/plugins"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
+	addmissionV1 "k8s.io/api/admission/v1"
+	addmissionV1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestServeAnswersInTheVersionOfTheRequest(t *testing.T) {
+	ws := &WebHookServer{pluginManager: plugins.NewPluginManager(), Options: &WebHookOptions{}}
+	pod, err := json.Marshal(&v1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "game", Namespace: "default", Annotations: map[string]string{faketime.FakeTime: "+1h"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	podResource := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	tests := []struct {
+		name       string
+		path       string
+		apiVersion string
+		uid        types.UID
+		patched    bool
+	}{
+		{name: "v1 mutate", path: MutatingWebhookConfigurationPath, apiVersion: "admission.k8s.io/v1", uid: "uid-v1-mutate", patched: true},
+		{name: "v1beta1 mutate", path: MutatingWebhookConfigurationPath, apiVersion: "admission.k8s.io/v1beta1", uid: "uid-v1beta1-mutate", patched: true},
+		{name: "v1 validate", path: ValidatingWebhookConfigurationPath, apiVersion: "admission.k8s.io/v1", uid: "uid-v1-validate"},
+		{name: "v1beta1 validate", path: ValidatingWebhookConfigurationPath, apiVersion: "admission.k8s.io/v1beta1", uid: "uid-v1beta1-validate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			typeMeta := metav1.TypeMeta{APIVersion: tt.apiVersion, Kind: "AdmissionReview"}
+			if tt.apiVersion == addmissionV1beta1.SchemeGroupVersion.String() {
+				body, err = json.Marshal(&addmissionV1beta1.AdmissionReview{TypeMeta: typeMeta, Request: &addmissionV1beta1.AdmissionRequest{
+					UID:       tt.uid,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Resource:  podResource,
+					Namespace: "default",
+					Operation: addmissionV1beta1.Create,
+					Object:    runtime.RawExtension{Raw: pod},
+				}})
+			} else {
+				body, err = json.Marshal(&addmissionV1.AdmissionReview{TypeMeta: typeMeta, Request: &addmissionV1.AdmissionRequest{
+					UID:       tt.uid,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Resource:  podResource,
+					Namespace: "default",
+					Operation: addmissionV1.Create,
+					Object:    runtime.RawExtension{Raw: pod},
+				}})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			review := serve(t, ws, tt.path, body)
+			if review.APIVersion != tt.apiVersion || review.Kind != "AdmissionReview" {
+				t.Errorf("expected AdmissionReview %s, got %s %s", tt.apiVersion, review.Kind, review.APIVersion)
+			}
+			if review.Response == nil {
+				t.Fatal("response is missing")
+			}
+			if review.Response.UID != tt.uid {
+				t.Errorf("expected uid %s, got %s", tt.uid, review.Response.UID)
+			}
+			if !review.Response.Allowed {
+				t.Errorf("expected the pod to be allowed, got %+v", review.Response.Result)
+			}
+			if patched := len(review.Response.Patch) > 0; patched != tt.patched {
+				t.Errorf("expected patched %v, got patch %s", tt.patched, review.Response.Patch)
+			}
+			if tt.patched && (review.Response.PatchType == nil || *review.Response.PatchType != addmissionV1.PatchTypeJSONPatch) {
+				t.Errorf("expected JSONPatch, got %v", review.Response.PatchType)
+			}
+		})
+	}
+}
+
+func TestServeRejectsMalformedRequests(t *testing.T) {
+	ws := &WebHookServer{pluginManager: plugins.NewPluginManager(), Options: &WebHookOptions{}}
+
+	t.Run("empty body", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		ws.Serve(recorder, httptest.NewRequest(http.MethodPost, MutatingWebhookConfigurationPath, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected %d, got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+	t.Run("wrong content type", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		ws.Serve(recorder, httptest.NewRequest(http.MethodPost, MutatingWebhookConfigurationPath, bytes.NewBufferString("{}")))
+		if recorder.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("expected %d, got %d", http.StatusUnsupportedMediaType, recorder.Code)
+		}
+	})
+	t.Run("review without request", func(t *testing.T) {
+		for _, apiVersion := range []string{"admission.k8s.io/v1", "admission.k8s.io/v1beta1"} {
+			body, _ := json.Marshal(&metav1.TypeMeta{APIVersion: apiVersion, Kind: "AdmissionReview"})
+			review := serve(t, ws, MutatingWebhookConfigurationPath, body)
+			if review.APIVersion != apiVersion {
+				t.Errorf("expected %s, got %s", apiVersion, review.APIVersion)
+			}
+			if review.Response == nil || review.Response.Allowed || review.Response.Result == nil {
+				t.Errorf("expected an error response, got %+v", review.Response)
+			}
+		}
+	})
+	t.Run("undecodable review", func(t *testing.T) {
+		review := serve(t, ws, MutatingWebhookConfigurationPath, []byte(`{"apiVersion":"v1","kind":"Pod"}`))
+		if review.Response == nil || review.Response.Allowed || review.Response.Result == nil {
+			t.Errorf("expected an error response, got %+v", review.Response)
+		}
+	})
+}
+
+func TestServeAuditsEveryResponse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	ws := &WebHookServer{pluginManager: plugins.NewPluginManager(), Options: &WebHookOptions{AuditLogAll: true}, auditLogger: logger}
+	review := func(annotations map[string]string) []byte {
+		pod, _ := json.Marshal(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "game", Namespace: "default", Annotations: annotations},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		})
+		body, _ := json.Marshal(&addmissionV1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &addmissionV1.AdmissionRequest{
+				UID:       "uid",
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Namespace: "default",
+				Operation: addmissionV1.Create,
+				Object:    runtime.RawExtension{Raw: pod},
+			},
+		})
+		return body
+	}
+
+	ws.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, MutatingWebhookConfigurationPath, nil))
+	serve(t, ws, MutatingWebhookConfigurationPath, []byte(`{"apiVersion":"v1","kind":"Pod"}`))
+	serve(t, ws, ValidatingWebhookConfigurationPath, []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`))
+	if response := serve(t, ws, MutatingWebhookConfigurationPath, review(nil)).Response; response.AuditAnnotations["outcome"] != metrics.OutcomeAllowed {
+		t.Errorf("expected the audit annotations of the response, got %v", response.AuditAnnotations)
+	}
+	// the objects without annotations are only audited with --audit-log-all
+	ws.Options.AuditLogAll = false
+	if response := serve(t, ws, MutatingWebhookConfigurationPath, review(nil)).Response; len(response.AuditAnnotations) != 0 {
+		t.Errorf("expected no audit annotations of the response, got %v", response.AuditAnnotations)
+	}
+	serve(t, ws, MutatingWebhookConfigurationPath, review(map[string]string{faketime.FakeTime: "+1h"}))
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		webhook string
+		outcome string
+		name    string
+	}{
+		{webhook: "mutate", outcome: metrics.OutcomeError},
+		{webhook: "mutate", outcome: metrics.OutcomeError},
+		{webhook: "validate", outcome: metrics.OutcomeError},
+		{webhook: "mutate", outcome: metrics.OutcomeAllowed, name: "game"},
+		{webhook: "mutate", outcome: metrics.OutcomePatched, name: "game"},
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d records, got %d:\n%s", len(expected), len(lines), raw)
+	}
+	for i, line := range lines {
+		record := &audit.Record{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Webhook != expected[i].webhook || record.Outcome != expected[i].outcome || record.Name != expected[i].name {
+			t.Errorf("record %d: expected %+v, got %s", i, expected[i], line)
+		}
+		if record.Outcome == metrics.OutcomeError && len(record.Messages) == 0 {
+			t.Errorf("record %d: expected the reason of the error, got %s", i, line)
+		}
+	}
+}
+
+// serve posts the body to the path and decodes the AdmissionReview of the response, v1beta1 has the same json schema as v1
+func serve(t *testing.T, ws *WebHookServer, path string, body []byte) *addmissionV1.AdmissionReview {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	ws.Serve(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	review := &addmissionV1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), review); err != nil {
+		t.Fatalf("failed to decode %s: %v", recorder.Body.String(), err)
+	}
+	return review
+}
@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"context"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	log "k8s.io/klog"
+	"time"
+)
+
+const (
+	// ConfigMap sharing the cluster mode anchors among the replicas when leader election is enabled
+	AnchorConfigMapName = "kubernetes-faketime-injector-anchors"
+	// interval to remove the expired cluster mode anchors
+	anchorGCInterval = 10 * time.Second
+)
+
+// collectAnchors periodically removes the expired cluster mode anchors, the shared anchors are removed by the leader only
+func (ws *WebHookServer) collectAnchors(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := faketime.ExpireAnchors(); err != nil {
+			log.Errorf("Failed to remove expired anchors,because of %v", err)
+		}
+	}, anchorGCInterval)
+	return nil
+}
@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/audit"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	addmissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog"
+	"strings"
+	"time"
+)
+
+// errorReasons are the results of the requests which could not be handled, they are audited as errors
+var errorReasons = map[metav1.StatusReason]bool{
+	metav1.StatusReasonBadRequest:           true,
+	metav1.StatusReasonUnsupportedMediaType: true,
+	metav1.StatusReasonInternalError:        true,
+}
+
+// auditDecision records the responses of the webhook on objects with cloudnativegame.io annotations, and the failed
+// requests, in the audit log and in the audit annotations of the response, which the api server adds to its audit events.
+// The responses on all objects are recorded with --audit-log-all. req is nil if the review could not be decoded.
+func (ws *WebHookServer) auditDecision(webhook string, req *addmissionV1.AdmissionRequest, response *addmissionV1.AdmissionResponse) {
+	record := &audit.Record{
+		Time:    time.Now().UTC(),
+		Webhook: webhook,
+		Outcome: metrics.OutcomeAllowed,
+	}
+	annotated := false
+	if req != nil {
+		record.RequestUID = string(req.UID)
+		record.Operation = string(req.Operation)
+		record.User = req.UserInfo.Username
+		record.Groups = req.UserInfo.Groups
+		record.Namespace = req.Namespace
+		record.Kind = req.Kind.Kind
+		record.Name = req.Name
+		annotated = auditObject(record, req, response)
+	}
+
+	switch {
+	case response == nil || response.Result != nil && errorReasons[response.Result.Reason]:
+		record.Outcome = metrics.OutcomeError
+	case !response.Allowed:
+		record.Outcome = metrics.OutcomeDenied
+	case len(response.Patch) > 0:
+		record.Outcome = metrics.OutcomePatched
+	}
+	if !annotated && record.Outcome != metrics.OutcomeError && !ws.Options.AuditLogAll {
+		return
+	}
+	if response != nil {
+		if response.Result != nil && response.Result.Message != "" {
+			record.Messages = append(record.Messages, response.Result.Message)
+		}
+		record.Messages = append(record.Messages, response.Warnings...)
+		response.AuditAnnotations = auditAnnotations(record)
+	}
+	if err := ws.auditLogger.Log(record); err != nil {
+		log.Errorf("Failed to write audit log of %s %s/%s,because of %v", record.Kind, record.Namespace, record.Name, err)
+	}
+}
+
+// auditObject records the owner and the fake time of the pod or the pod template of the request,
+// and returns true if it has cloudnativegame.io annotations before or after the request
+func auditObject(record *audit.Record, req *addmissionV1.AdmissionRequest, response *addmissionV1.AdmissionResponse) bool {
+	raw := req.Object.Raw
+	if len(raw) == 0 {
+		raw = req.OldObject.Raw
+	}
+	pod, err := podOfRequest(raw, req.Resource)
+	if err != nil || pod == nil {
+		return false
+	}
+	annotated := len(injectorAnnotations(pod)) > 0
+	if !annotated {
+		oldPod, err := podOfRequest(req.OldObject.Raw, req.Resource)
+		annotated = err == nil && oldPod != nil && len(injectorAnnotations(oldPod)) > 0
+	}
+	if record.Name == "" {
+		record.Name = podName(pod)
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		record.Owner = owner.Kind + "/" + owner.Name
+	}
+	record.FakeTime = pod.Annotations[faketime.FakeTime]
+
+	// the annotations written by the patch override the ones of the object
+	injected := map[string]string{
+		faketime.InjectedMode:    pod.Annotations[faketime.InjectedMode],
+		faketime.EffectiveOffset: pod.Annotations[faketime.EffectiveOffset],
+		faketime.AnchorGroup:     pod.Annotations[faketime.AnchorGroup],
+	}
+	if response != nil && len(response.Patch) > 0 {
+		var ops []utils.PatchOperation
+		if err := json.Unmarshal(response.Patch, &ops); err == nil {
+			for _, op := range ops {
+				for key := range injected {
+					if value, ok := op.Value.(string); ok && strings.HasSuffix(op.Path, "/metadata/annotations/"+utils.EscapeJSONPointer(key)) {
+						injected[key] = value
+					}
+				}
+			}
+		}
+	}
+	record.Mode = injected[faketime.InjectedMode]
+	record.EffectiveOffset = injected[faketime.EffectiveOffset]
+	record.AnchorGroup = injected[faketime.AnchorGroup]
+	return annotated
+}
+
+// auditAnnotations are prefixed with the name of the webhook by the api server, e.g. <webhook>/outcome
+func auditAnnotations(record *audit.Record) map[string]string {
+	annotations := map[string]string{"outcome": record.Outcome}
+	for key, value := range map[string]string{
+		"fake-time":        record.FakeTime,
+		"mode":             record.Mode,
+		"effective-offset": record.EffectiveOffset,
+		"anchor-group":     record.AnchorGroup,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
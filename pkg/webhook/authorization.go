@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/plugins"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
+	addmissionV1 "k8s.io/api/admission/v1"
+	authorizationV1 "k8s.io/api/authorization/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	log "k8s.io/klog"
+	"net/http"
+	"reflect"
+)
+
+const (
+	// the virtual resource checked by SubjectAccessReviews, e.g. granted by
+	// rules: [{apiGroups: ["cloudnativegame.io"], resources: ["faketimes"], verbs: ["inject"]}]
+	AuthorizationGroup    = "cloudnativegame.io"
+	AuthorizationResource = "faketimes"
+	AuthorizationVerb     = "inject"
+
+	// the requests of unauthorized users are rejected
+	AuthorizationDeny = "deny"
+	// the requests of unauthorized users are admitted without fake time, with a warning
+	AuthorizationSkip = "skip"
+)
+
+var AuthorizationPath = "/authorize"
+
+// recordedAnnotations are written by the injector, they are not requested by the user
+var recordedAnnotations = map[string]bool{
+	faketime.FakeTimeInjected: true,
+	faketime.InjectedMode:     true,
+	faketime.EffectiveOffset:  true,
+	faketime.AnchorGroup:      true,
+	faketime.FakeTimeSpec:     true,
+	plugins.InjectorVersion:   true,
+}
+
+// authorize rejects the resources carrying a pod template which request fake time on behalf of an unauthorized user.
+// It is registered with FailurePolicy Fail, a workload admitted while the injector is down would get its pods injected later.
+func (ws *WebHookServer) authorize(ar *addmissionV1.AdmissionReview) *addmissionV1.AdmissionResponse {
+	req := ar.Request
+	pod, err := podOfRequest(req.Object.Raw, req.Resource)
+	if err != nil {
+		log.Errorf("Failed to decode %s %s/%s,because of %v", req.Resource.Resource, req.Namespace, req.Name, err)
+		return ws.unauthorizedResponse(req, fmt.Sprintf("failed to decode the pod template: %v", err))
+	}
+	if pod != nil {
+		if allowed, reason := ws.authorizeInjection(req, pod); !allowed {
+			return ws.unauthorizedResponse(req, reason)
+		}
+	}
+	metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+	return &addmissionV1.AdmissionResponse{Allowed: true}
+}
+
+// injectionRequested returns true if the request sets or changes the cloudnativegame.io annotations of the pod,
+// removing them does not need the permission
+func injectionRequested(req *addmissionV1.AdmissionRequest, pod *v1.Pod) bool {
+	annotations := requestedAnnotations(pod)
+	if len(annotations) == 0 {
+		return false
+	}
+	if req.Operation != addmissionV1.Update {
+		return true
+	}
+	oldPod, err := podOfRequest(req.OldObject.Raw, req.Resource)
+	if err != nil || oldPod == nil {
+		return true
+	}
+	return !reflect.DeepEqual(requestedAnnotations(oldPod), annotations)
+}
+
+// requestedAnnotations returns the cloudnativegame.io annotations of the pod set by the user
+func requestedAnnotations(pod *v1.Pod) map[string]string {
+	annotations := injectorAnnotations(pod)
+	for key := range recordedAnnotations {
+		delete(annotations, key)
+	}
+	return annotations
+}
+
+// authorizeInjection checks the requester may inject fake time in the namespace of the request,
+// the reason of the denial is returned if not
+func (ws *WebHookServer) authorizeInjection(req *addmissionV1.AdmissionRequest, pod *v1.Pod) (bool, string) {
+	if ws.Options.InjectionAuthorization == "" || !injectionRequested(req, pod) {
+		return true, ""
+	}
+	if ws.authorizedByOwner(req.Namespace, pod) {
+		return true, ""
+	}
+
+	extra := make(map[string]authorizationV1.ExtraValue, len(req.UserInfo.Extra))
+	for k, v := range req.UserInfo.Extra {
+		extra[k] = authorizationV1.ExtraValue(v)
+	}
+	sar := &authorizationV1.SubjectAccessReview{
+		Spec: authorizationV1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationV1.ResourceAttributes{
+				Namespace: req.Namespace,
+				Verb:      AuthorizationVerb,
+				Group:     AuthorizationGroup,
+				Resource:  AuthorizationResource,
+				Name:      req.Name,
+			},
+			User:   req.UserInfo.Username,
+			UID:    req.UserInfo.UID,
+			Groups: req.UserInfo.Groups,
+			Extra:  extra,
+		},
+	}
+	result, err := ws.clientSet.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), sar, metav1.CreateOptions{})
+	if err != nil {
+		log.Errorf("Failed to authorize %s to inject fake time in %s,because of %v", req.UserInfo.Username, req.Namespace, err)
+		return false, fmt.Sprintf("failed to check the permission of %s to %s %s.%s in namespace %s: %v",
+			req.UserInfo.Username, AuthorizationVerb, AuthorizationResource, AuthorizationGroup, req.Namespace, err)
+	}
+	if result.Status.Allowed {
+		return true, ""
+	}
+	reason := fmt.Sprintf("user %s is not allowed to %s %s.%s in namespace %s", req.UserInfo.Username,
+		AuthorizationVerb, AuthorizationResource, AuthorizationGroup, req.Namespace)
+	if result.Status.Reason != "" {
+		reason = fmt.Sprintf("%s: %s", reason, result.Status.Reason)
+	}
+	return false, reason
+}
+
+// unauthorizedResponse denies the request, or admits a pod without fake time when the unauthorized requests are skipped.
+// Workloads are always denied, their pods are authorized by the workload and would be injected anyway.
+func (ws *WebHookServer) unauthorizedResponse(req *addmissionV1.AdmissionRequest, reason string) *addmissionV1.AdmissionResponse {
+	if ws.Options.InjectionAuthorization == AuthorizationSkip && req.Resource.Resource == "pods" {
+		log.Warningf("Skip injecting fake time into %s %s/%s, %s", req.Kind.Kind, req.Namespace, req.Name, reason)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+		return &addmissionV1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{"fake time is not injected, " + reason},
+		}
+	}
+	log.Infof("Deny %s %s/%s, %s", req.Kind.Kind, req.Namespace, req.Name, reason)
+	metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeDenied).Inc()
+	return &addmissionV1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: reason,
+		},
+	}
+}
+
+// authorizedByOwner returns true if the annotations of the pod, or of the pod template, are copied from the template of
+// its controller, e.g. a ReplicaSet creating pods or a Deployment creating ReplicaSets. The controller carries a pod
+// template as well, so it was authorized when it was written, whoever the controller creating the object is.
+func (ws *WebHookServer) authorizedByOwner(namespace string, pod *v1.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || ws.dynamicClient == nil {
+		return false
+	}
+	w, ok := matchWorkloadKind(owner.APIVersion, owner.Kind)
+	if !ok {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return false
+	}
+	obj, err := ws.dynamicClient.Resource(gv.WithResource(w.Resource)).Namespace(namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Warningf("Failed to get the owner %s %s/%s,because of %v", owner.Kind, namespace, owner.Name, err)
+		return false
+	}
+	if obj.GetUID() != owner.UID {
+		return false
+	}
+	template, found, err := podTemplateOf(obj.Object, w)
+	if err != nil || !found {
+		return false
+	}
+	for key, value := range requestedAnnotations(pod) {
+		if template.Annotations[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+	log "k8s.io/klog"
+	"sync/atomic"
+	"time"
+)
+
+// interval to reload the shared certs until the leader has provisioned them
+const certLoadRetryPeriod = 10 * time.Second
+
+// setCerts swaps the serving certificate, new TLS handshakes use it immediately
+func (ws *WebHookServer) setCerts(certs *generator.Artifacts, pair tls.Certificate) {
+	ws.certMu.Lock()
+	defer ws.certMu.Unlock()
+	if ws.certs != nil && !bytes.Equal(ws.certs.CACert, certs.CACert) {
+		ws.previousCACert = ws.certs.CACert
+	}
+	ws.certs = certs
+	ws.tlsPair = &pair
+	if leaf, err := x509.ParseCertificate(pair.Certificate[0]); err == nil {
+		metrics.CertificateExpiry.Set(float64(leaf.NotAfter.Unix()))
+	}
+}
+
+// getCertificate is the tls.Config callback serving the current certificate
+func (ws *WebHookServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	ws.certMu.RLock()
+	defer ws.certMu.RUnlock()
+	if ws.tlsPair == nil {
+		return nil, fmt.Errorf("serving certificate is not loaded")
+	}
+	return ws.tlsPair, nil
+}
+
+// caBundle returns the current CA, followed by the previous CA while replicas may still serve certificates signed by it
+func (ws *WebHookServer) caBundle() []byte {
+	ws.certMu.RLock()
+	defer ws.certMu.RUnlock()
+	if ws.certs == nil {
+		return nil
+	}
+	bundle := append([]byte{}, ws.certs.CACert...)
+	if len(ws.previousCACert) > 0 {
+		bundle = append(bundle, ws.previousCACert...)
+	}
+	return bundle
+}
+
+// rotateCerts re-checks the certificate periodically, regenerates it when it expires soon and reloads it without restart.
+// The shared certs are regenerated by the leader only, the other replicas reload them, more often until they are loaded.
+func (ws *WebHookServer) rotateCerts(ctx context.Context) {
+	for {
+		changed, err := ws.syncCerts()
+		if err != nil {
+			log.Errorf("Failed to ensure certs,because of %v", err)
+		}
+		if changed && ws.isLeader() {
+			if err := ws.registerWebhookConfigurations(); err != nil {
+				log.Errorf("Failed to update CABundle of the webhook configurations,because of %v", err)
+			}
+		}
+
+		interval := ws.Options.CertCheckInterval
+		if ws.caBundle() == nil && interval > certLoadRetryPeriod {
+			interval = certLoadRetryPeriod
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// syncCerts provisions the certs, or loads the shared certs provisioned by the leader, and swaps the serving certificate if it changed
+func (ws *WebHookServer) syncCerts() (bool, error) {
+	ws.certSyncMu.Lock()
+	defer ws.certSyncMu.Unlock()
+
+	var certs *generator.Artifacts
+	var err error
+	if ws.provisionsCerts() {
+		certs, _, err = ws.Options.ensureCert()
+	} else {
+		certs, err = ws.Options.loadCert()
+	}
+	if err != nil {
+		return false, err
+	}
+	ws.certMu.RLock()
+	current := ws.certs
+	ws.certMu.RUnlock()
+	if current != nil && bytes.Equal(current.Cert, certs.Cert) {
+		return false, nil
+	}
+
+	pair, err := tls.X509KeyPair(certs.Cert, certs.Key)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	ws.setCerts(certs, pair)
+	ws.resetRegistrationCheck()
+	log.Info("Serving certificate has been loaded")
+	return true, nil
+}
+
+// provisionsCerts returns true if this replica generates the certs, the shared certs are generated by the leader only
+func (ws *WebHookServer) provisionsCerts() bool {
+	return !ws.Options.sharedCerts() || ws.isLeader()
+}
+
+// isLeader returns true if this replica performs the leader tasks
+func (ws *WebHookServer) isLeader() bool {
+	return !ws.Options.LeaderElection || atomic.LoadInt32(&ws.leading) == 1
+}
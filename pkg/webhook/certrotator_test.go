@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/writer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSharedCertsAreProvisionedByTheLeaderOnly(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	newReplica := func(leading int32) *WebHookServer {
+		certWriter, err := writer.NewSecretCertWriter(writer.SecretCertWriterOptions{
+			Clientset:     clientSet,
+			CertGenerator: &generator.SelfSignedCertGenerator{KeyAlgorithm: generator.KeyAlgorithmECDSAP256},
+			Secret:        &types.NamespacedName{Namespace: "kube-system", Name: "certs"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &WebHookServer{
+			Options: &WebHookOptions{
+				LeaderElection: true,
+				CertWriterType: writer.SecretCertWriter,
+				CertWriter:     certWriter,
+				WebhookCertDir: t.TempDir(),
+				DnsName:        generator.ServiceToCommonName("kube-system", "injector"),
+			},
+			leading: leading,
+		}
+	}
+	leader, follower := newReplica(1), newReplica(0)
+
+	if _, err := follower.syncCerts(); err == nil {
+		t.Fatal("expected the follower to wait for the certs of the leader")
+	}
+	if follower.caBundle() != nil {
+		t.Fatal("the follower must not generate certs")
+	}
+
+	changed, err := leader.syncCerts()
+	if err != nil || !changed {
+		t.Fatalf("expected the leader to provision the certs, got %v %v", changed, err)
+	}
+	changed, err = follower.syncCerts()
+	if err != nil || !changed {
+		t.Fatalf("expected the follower to load the certs, got %v %v", changed, err)
+	}
+	if !bytes.Equal(follower.caBundle(), leader.caBundle()) {
+		t.Error("expected the replicas to share the CA")
+	}
+	if changed, err = follower.syncCerts(); err != nil || changed {
+		t.Errorf("expected the loaded certs to be unchanged, got %v %v", changed, err)
+	}
+}
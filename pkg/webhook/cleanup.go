@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	log "k8s.io/klog"
+	"time"
+)
+
+const (
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	LabelInstance  = "cloudnativegame.io/fake-time-injector-instance"
+	ManagedByValue = "fake-time-injector"
+	// interval to look for registrations whose service is gone
+	staleCheckInterval = 10 * time.Minute
+)
+
+// ownerLabels marks the objects created by this install, the instance is the service serving the webhook
+func ownerLabels(wo *WebHookOptions) map[string]string {
+	return map[string]string{
+		LabelManagedBy: ManagedByValue,
+		LabelInstance:  fmt.Sprintf("%s.%s", wo.ServiceName, wo.ServiceNamespace),
+	}
+}
+
+func ownedBy(objectLabels map[string]string, wo *WebHookOptions) bool {
+	for k, v := range ownerLabels(wo) {
+		if objectLabels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Cleanup deletes the MutatingWebhookConfiguration, the ValidatingWebhookConfiguration, the cert secret and the anchor ConfigMap owned by this install
+func Cleanup(wo *WebHookOptions) error {
+	config, err := clientcmd.BuildConfigFromFlags("", wo.KubeConf)
+	if err != nil {
+		return err
+	}
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	mwc, err := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), MutatingWebhookConfigurationName, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		log.Infof("MutatingWebhookConfiguration %s does not exist", MutatingWebhookConfigurationName)
+	case err != nil:
+		return err
+	case !ownedBy(mwc.Labels, wo):
+		log.Warningf("MutatingWebhookConfiguration %s is not owned by %s, skip deleting it", MutatingWebhookConfigurationName, ownerLabels(wo)[LabelInstance])
+	default:
+		if err := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(context.TODO(), MutatingWebhookConfigurationName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %v", MutatingWebhookConfigurationName, err)
+		}
+		log.Infof("MutatingWebhookConfiguration %s has been deleted", MutatingWebhookConfigurationName)
+	}
+	if err := deleteValidatingWebhookConfiguration(clientSet, wo); err != nil {
+		return err
+	}
+
+	secret, err := clientSet.CoreV1().Secrets(wo.CertSecretNamespace).Get(context.TODO(), wo.CertSecretName, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		log.Infof("Secret %s/%s does not exist", wo.CertSecretNamespace, wo.CertSecretName)
+	case err != nil:
+		return err
+	case !ownedBy(secret.Labels, wo):
+		log.Warningf("Secret %s/%s is not owned by %s, skip deleting it", wo.CertSecretNamespace, wo.CertSecretName, ownerLabels(wo)[LabelInstance])
+	default:
+		if err := clientSet.CoreV1().Secrets(wo.CertSecretNamespace).Delete(context.TODO(), wo.CertSecretName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s/%s: %v", wo.CertSecretNamespace, wo.CertSecretName, err)
+		}
+		log.Infof("Secret %s/%s has been deleted", wo.CertSecretNamespace, wo.CertSecretName)
+	}
+
+	cm, err := clientSet.CoreV1().ConfigMaps(wo.ServiceNamespace).Get(context.TODO(), AnchorConfigMapName, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		log.Infof("ConfigMap %s/%s does not exist", wo.ServiceNamespace, AnchorConfigMapName)
+	case err != nil:
+		return err
+	case !ownedBy(cm.Labels, wo):
+		log.Warningf("ConfigMap %s/%s is not owned by %s, skip deleting it", wo.ServiceNamespace, AnchorConfigMapName, ownerLabels(wo)[LabelInstance])
+	default:
+		if err := clientSet.CoreV1().ConfigMaps(wo.ServiceNamespace).Delete(context.TODO(), AnchorConfigMapName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete configmap %s/%s: %v", wo.ServiceNamespace, AnchorConfigMapName, err)
+		}
+		log.Infof("ConfigMap %s/%s has been deleted", wo.ServiceNamespace, AnchorConfigMapName)
+	}
+	return nil
+}
+
+// detectStaleRegistrations periodically reports the registrations managed by any fake-time-injector whose service is missing
+func (ws *WebHookServer) detectStaleRegistrations(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		selector := labels.SelectorFromSet(labels.Set{LabelManagedBy: ManagedByValue}).String()
+		mwcs, err := ws.clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			log.Errorf("Failed to list MutatingWebhookConfigurations,because of %v", err)
+			return
+		}
+		for i := range mwcs.Items {
+			mwc := &mwcs.Items[i]
+			for _, webhook := range mwc.Webhooks {
+				svc := webhook.ClientConfig.Service
+				if svc == nil {
+					continue
+				}
+				_, err := ws.clientSet.CoreV1().Services(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+				if !errors.IsNotFound(err) {
+					continue
+				}
+				log.Warningf("MutatingWebhookConfiguration %s is stale, webhook %s points at the missing service %s/%s", mwc.Name, webhook.Name, svc.Namespace, svc.Name)
+				if ws.recorder != nil {
+					ws.recorder.Eventf(mwc, v1.EventTypeWarning, "StaleRegistration", "Webhook %s points at the missing service %s/%s", webhook.Name, svc.Namespace, svc.Name)
+				}
+			}
+		}
+	}, staleCheckInterval)
+	return nil
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	log "k8s.io/klog"
+	"time"
+)
+
+const (
+	EventSourceComponent = "fake-time-injector"
+	// how long to wait for a pod without owner to be created before dropping its events
+	podCreationTimeout = 30 * time.Second
+	// how often the pending pod events look up their pod
+	podEventsRetryPeriod = time.Second
+)
+
+// newEventBroadcaster returns a broadcaster which writes events to the api server
+func newEventBroadcaster(clientSet kubernetes.Interface) record.EventBroadcaster {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	return broadcaster
+}
+
+// recordEvents records the plugin results against the controller of the pod, or against the pod itself.
+// It never blocks the admission, the events of a pod being created without owner are recorded once it exists.
+func (ws *WebHookServer) recordEvents(pod *v1.Pod, results []utils.PatchResult) {
+	if ws.recorder == nil || len(results) == 0 {
+		return
+	}
+
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		ref := &v1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        owner.UID,
+			Namespace:  pod.Namespace,
+		}
+		ws.emitEvents(ref, "Pod "+podName(pod), results)
+		return
+	}
+	if pod.UID != "" {
+		ws.emitEvents(podReference(pod), "Pod "+podName(pod), results)
+		return
+	}
+
+	if pod.Name == "" || ws.podEvents == nil {
+		log.V(5).Infof("Skip events of pod %s without owner in %s", podName(pod), pod.Namespace)
+		return
+	}
+	// the pod has no UID during admission, and may still be rejected after it
+	ws.podEvents.AddAfter(&pendingPodEvents{
+		namespace: pod.Namespace,
+		name:      pod.Name,
+		results:   results,
+		deadline:  time.Now().Add(podCreationTimeout),
+	}, podEventsRetryPeriod)
+}
+
+// pendingPodEvents are the results of a pod without owner, waiting for the pod to be created
+type pendingPodEvents struct {
+	namespace string
+	name      string
+	results   []utils.PatchResult
+	deadline  time.Time
+}
+
+// runPodEvents records the pending pod events until the queue is shut down
+func (ws *WebHookServer) runPodEvents() {
+	for {
+		item, shutdown := ws.podEvents.Get()
+		if shutdown {
+			return
+		}
+		ws.recordPendingPodEvents(item.(*pendingPodEvents))
+		ws.podEvents.Done(item)
+	}
+}
+
+// recordPendingPodEvents records the events against the created pod, or waits for it until the deadline.
+// The events of a pod which is never created, e.g. denied by another webhook, are dropped.
+func (ws *WebHookServer) recordPendingPodEvents(pending *pendingPodEvents) {
+	ctx, cancel := context.WithTimeout(context.Background(), podEventsRetryPeriod)
+	defer cancel()
+	pod, err := ws.clientSet.CoreV1().Pods(pending.namespace).Get(ctx, pending.name, metav1.GetOptions{})
+	if err == nil {
+		ws.emitEvents(podReference(pod), "Pod "+pod.Name, pending.results)
+		return
+	}
+	if time.Now().Before(pending.deadline) {
+		ws.podEvents.AddAfter(pending, podEventsRetryPeriod)
+		return
+	}
+	log.V(5).Infof("Drop events of pod %s/%s which has not been created,because of %v", pending.namespace, pending.name, err)
+}
+
+func podReference(pod *v1.Pod) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		UID:        pod.UID,
+	}
+}
+
+// emitEvents records the plugin results of the subject, e.g. "Pod web-0", against the referenced object
+func (ws *WebHookServer) emitEvents(ref *v1.ObjectReference, subject string, results []utils.PatchResult) {
+	if ws.recorder == nil {
+		return
+	}
+	for _, result := range results {
+		eventType := v1.EventTypeNormal
+		if isWarning(result.Reason) {
+			eventType = v1.EventTypeWarning
+		}
+		ws.recorder.Eventf(ref, eventType, result.Reason, "%s: %s", subject, result.Message)
+	}
+}
+
+// isWarning returns true if the plugin could not patch the object as requested, or patched it against the policy
+func isWarning(reason string) bool {
+	switch reason {
+	case utils.ReasonInvalidSpec, utils.ReasonRestartRequired, utils.ReasonPolicyViolation, utils.ReasonPolicyWarning:
+		return true
+	}
+	return false
+}
+
+// podName returns the name or the generateName of the pod
+func podName(pod *v1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// refRecorder records the involved object of every event
+type refRecorder struct {
+	record.FakeRecorder
+	refs chan *v1.ObjectReference
+}
+
+func (r *refRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.refs <- object.(*v1.ObjectReference)
+}
+
+func TestRecordEvents(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	recorder := &refRecorder{refs: make(chan *v1.ObjectReference, 10)}
+	ws := &WebHookServer{clientSet: clientSet, recorder: recorder, podEvents: workqueue.NewDelayingQueue()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.runPodEvents()
+	}()
+	defer func() {
+		ws.podEvents.ShutDown()
+		<-done
+	}()
+	results := []utils.PatchResult{{Plugin: "faketime", Reason: utils.ReasonInjected, Message: "injected"}}
+	controller := true
+	next := func() *v1.ObjectReference {
+		select {
+		case ref := <-recorder.refs:
+			return ref
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	ws.recordEvents(&v1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "web-", Namespace: "default", OwnerReferences: []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", UID: "rs-uid", Controller: &controller},
+	}}}, results)
+	if ref := next(); ref == nil || ref.Kind != "ReplicaSet" || ref.UID != "rs-uid" {
+		t.Errorf("expected the event of the owned pod on its controller, got %+v", ref)
+	}
+
+	// the pod without owner is created after its admission, the event waits for its UID
+	ws.recordEvents(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}, results)
+	if _, err := clientSet.CoreV1().Pods("default").Create(context.TODO(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "pod-uid"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ref := next(); ref == nil || ref.Kind != "Pod" || ref.Name != "test" || ref.UID != "pod-uid" {
+		t.Errorf("expected the event on the created pod, got %+v", ref)
+	}
+
+	// a pod which is never created does not get events
+	ws.podEvents.Add(&pendingPodEvents{namespace: "default", name: "denied", results: results, deadline: time.Now()})
+	ws.recordEvents(&v1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-", Namespace: "default"}}, results)
+	select {
+	case ref := <-recorder.refs:
+		t.Errorf("expected no event, got %+v", ref)
+	case <-time.After(2 * podEventsRetryPeriod):
+	}
+	if n := ws.podEvents.Len(); n != 0 {
+		t.Errorf("expected the events to be dropped, %d are pending", n)
+	}
+}
@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// how long the result of the registration check is reused by the readiness probes
+const registrationCheckTTL = 30 * time.Second
+
+// Liveness handler, the server is alive as long as the serve loop is running
+func (ws *WebHookServer) Healthz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&ws.serving) == 0 {
+		http.Error(w, "webhook server is not serving", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+// Readiness handler, the server is ready once the certs are valid, the webhook is registered with the same CA and plugins are configured
+func (ws *WebHookServer) Readyz(w http.ResponseWriter, r *http.Request) {
+	if err := ws.ready(); err != nil {
+		log.V(5).Infof("Webhook server is not ready,because of %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (ws *WebHookServer) ready() error {
+	if atomic.LoadInt32(&ws.draining) == 1 {
+		return errors.New("webhook server is shutting down")
+	}
+	if atomic.LoadInt32(&ws.serving) == 0 {
+		return errors.New("webhook server is not serving")
+	}
+	if err := ws.checkCertificate(); err != nil {
+		return err
+	}
+	if err := ws.checkRegistration(); err != nil {
+		return err
+	}
+	if !ws.pluginManager.Configured() {
+		return errors.New("no plugin is configured")
+	}
+	return nil
+}
+
+// checkCertificate verifies the serving certificate is loaded and not expired
+func (ws *WebHookServer) checkCertificate() error {
+	pair, err := ws.getCertificate(nil)
+	if err != nil || len(pair.Certificate) == 0 {
+		return errors.New("serving certificate is not loaded")
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse serving certificate: %v", err)
+	}
+	if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("serving certificate is only valid from %v to %v", leaf.NotBefore, leaf.NotAfter)
+	}
+	return nil
+}
+
+// checkRegistration verifies the MutatingWebhookConfiguration trusts the CA of this server, the result is reused for
+// registrationCheckTTL so that the probes of all replicas do not hit the api server every time
+func (ws *WebHookServer) checkRegistration() error {
+	ws.registrationMu.Lock()
+	defer ws.registrationMu.Unlock()
+	if !ws.registrationCheckedAt.IsZero() && time.Since(ws.registrationCheckedAt) < registrationCheckTTL {
+		return ws.registrationErr
+	}
+	ws.registrationErr = ws.fetchRegistration()
+	ws.registrationCheckedAt = time.Now()
+	return ws.registrationErr
+}
+
+// resetRegistrationCheck drops the cached result once the certs or the registration changed
+func (ws *WebHookServer) resetRegistrationCheck() {
+	ws.registrationMu.Lock()
+	defer ws.registrationMu.Unlock()
+	ws.registrationCheckedAt = time.Time{}
+}
+
+// fetchRegistration compares the CABundle with the CA of this server, which is the CA shared by the leader with the secret writer
+func (ws *WebHookServer) fetchRegistration() error {
+	mwc, err := ws.clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), MutatingWebhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", MutatingWebhookConfigurationName, err)
+	}
+	for _, webhook := range mwc.Webhooks {
+		if webhook.Name != ws.Options.DnsName {
+			continue
+		}
+		ws.certMu.RLock()
+		certs := ws.certs
+		ws.certMu.RUnlock()
+		if certs == nil || !bytes.Contains(webhook.ClientConfig.CABundle, certs.CACert) {
+			return fmt.Errorf("CABundle of webhook %s does not match the CA of the server, more than one replica requires --cert-writer=secret", webhook.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("webhook %s is not registered in %s", ws.Options.DnsName, MutatingWebhookConfigurationName)
+}
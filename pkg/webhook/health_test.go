@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+	mutateV1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRegistrationCheckIsCached(t *testing.T) {
+	dnsName := generator.ServiceToCommonName("kube-system", "injector")
+	shared := &generator.Artifacts{CACert: []byte("shared-ca")}
+	clientSet := fake.NewSimpleClientset(&mutateV1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: MutatingWebhookConfigurationName},
+		Webhooks: []mutateV1.MutatingWebhook{
+			{Name: dnsName, ClientConfig: mutateV1.WebhookClientConfig{CABundle: []byte("new-ca\nshared-ca")}},
+		},
+	})
+	ws := &WebHookServer{clientSet: clientSet, Options: &WebHookOptions{DnsName: dnsName}, certs: shared}
+
+	for i := 0; i < 3; i++ {
+		if err := ws.checkRegistration(); err != nil {
+			t.Fatalf("expected the shared CA to be registered, got %v", err)
+		}
+	}
+	if gets := len(clientSet.Actions()); gets != 1 {
+		t.Errorf("expected the registration to be fetched once, got %d requests", gets)
+	}
+
+	// a replica with its own CA is not ready, until the changed certs reset the cache
+	ws.certs = &generator.Artifacts{CACert: []byte("own-ca")}
+	ws.resetRegistrationCheck()
+	if err := ws.checkRegistration(); err == nil {
+		t.Error("expected a CA missing in the CABundle to fail the check")
+	}
+
+	mwc, _ := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), MutatingWebhookConfigurationName, metav1.GetOptions{})
+	mwc.Webhooks[0].ClientConfig.CABundle = []byte("own-ca")
+	if _, err := clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), mwc, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.checkRegistration(); err == nil {
+		t.Error("expected the cached failure to be reused")
+	}
+	ws.resetRegistrationCheck()
+	if err := ws.checkRegistration(); err != nil {
+		t.Errorf("expected the updated registration to pass, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	log "k8s.io/klog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+	// interval to retry a failed leader task
+	leaderTaskRetryPeriod = 5 * time.Second
+)
+
+// leaderTask is the work that only one replica performs, it runs until the context is canceled
+type leaderTask func(ctx context.Context) error
+
+// runLeaderTasks runs the tasks directly, or only while this replica holds the lease if leader election is enabled.
+// All replicas keep serving admission requests.
+func (ws *WebHookServer) runLeaderTasks(ctx context.Context, tasks ...leaderTask) error {
+	if !ws.Options.LeaderElection {
+		// the first task registers the webhook, it must succeed before serving
+		if len(tasks) > 0 {
+			if err := tasks[0](ctx); err != nil {
+				return err
+			}
+		}
+		for _, task := range tasks[1:] {
+			task := task
+			ws.background.Add(1)
+			go func() {
+				defer ws.background.Done()
+				retryLeaderTask(ctx, task)
+			}()
+		}
+		return nil
+	}
+
+	// the hostname is the pod name, which is unique among the replicas
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %v", err)
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock,
+		ws.Options.LeaderElectionNamespace,
+		ws.Options.LeaderElectionID,
+		ws.clientSet.CoreV1(),
+		ws.clientSet.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return fmt.Errorf("failed to create leader election lock: %v", err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            ws.Options.LeaderElectionID,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("%s became the leader", identity)
+				atomic.StoreInt32(&ws.leading, 1)
+				for _, task := range tasks {
+					task := task
+					ws.background.Add(1)
+					go func() {
+						defer ws.background.Done()
+						retryLeaderTask(ctx, task)
+					}()
+				}
+			},
+			OnStoppedLeading: func() {
+				log.Infof("%s stopped leading", identity)
+				atomic.StoreInt32(&ws.leading, 0)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.Infof("The current leader is %s", current)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create leader elector: %v", err)
+	}
+
+	ws.background.Add(1)
+	go func() {
+		defer ws.background.Done()
+		// campaign again after losing the lease until the server stops
+		wait.UntilWithContext(ctx, elector.Run, retryPeriod)
+	}()
+	return nil
+}
+
+// retryLeaderTask retries the task until it succeeds or the leadership is lost
+func retryLeaderTask(ctx context.Context, task leaderTask) {
+	_ = wait.PollImmediateUntilWithContext(ctx, leaderTaskRetryPeriod, func(ctx context.Context) (bool, error) {
+		if err := task(ctx); err != nil {
+			log.Errorf("Failed to run leader task,because of %v", err)
+			return false, nil
+		}
+		return true, nil
+	})
+}
@@ -2,23 +2,39 @@ package webhook
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
 	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/writer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	log "k8s.io/klog"
+	"strings"
+	"time"
 )
 
 type WebHookOptions struct {
 	TLSPair tls.Certificate
 	// Server Port
 	Port string
+	// plain http port of the metrics endpoint
+	MetricsPort string
+	// plain http port of the health endpoints
+	HealthPort string
+	// how long the readiness fails before the servers are shut down
+	ShutdownDrainPeriod time.Duration
+	// how long to wait for in-flight requests during shutdown
+	ShutdownTimeout time.Duration
 	//service configuration
 	ServiceName      string
 	ServiceNamespace string
-	// leader election option
-	LeaderElection bool
+	// leader election option, only the leader registers the webhook, provisions the shared certs and removes the expired anchors
+	LeaderElection          bool
+	LeaderElectionNamespace string
+	LeaderElectionID        string
 	// kubeconf path
 	KubeConf string
 	// plugin and configuration
@@ -26,13 +42,62 @@ type WebHookOptions struct {
 	WebhookCertDir string
 	CaCert         *generator.Artifacts
 	DnsName        string
+	// writer ensuring the certs, it is called again by the rotator
+	CertWriter writer.CertWriter
+	// fs, secret or external
+	CertWriterType string
+	// secret storing the certs shared by all replicas when the secret cert writer is used
+	CertSecretName      string
+	CertSecretNamespace string
+	// how often the certificate expiry is checked
+	CertCheckInterval time.Duration
+	// self signed certificate options
+	CertKeyAlgorithm string
+	CAValidity       time.Duration
+	CertValidity     time.Duration
+	CertRenewBefore  time.Duration
+	CertExtraSANs    string
+	// delete the resources owned by this install and exit
+	Cleanup bool
+	// mutate the pod templates of workloads as well as pods
+	MutateWorkloads bool
+	// reject the pods and workloads with invalid fake time annotations
+	ValidatingWebhook bool
+	// admit invalid annotations with warnings in all namespaces, or in the listed ones
+	ValidationWarnOnly       bool
+	ValidationWarnNamespaces string
+	// check the requesters may inject fake time by SubjectAccessReviews, deny or skip, disabled if empty
+	InjectionAuthorization string
+	// structured audit log of the admission decisions, - for stdout, disabled if empty
+	AuditLog           string
+	AuditLogMaxSize    int
+	AuditLogMaxBackups int
+	// also audit the objects without cloudnativegame.io annotations
+	AuditLogAll bool
+	// tls hardening options of the webhook server
+	TLSMinVersion         string
+	TLSCipherSuites       string
+	ClientCAFile          string
+	ClientCertCommonNames string
+	tlsMinVersion         uint16
+	tlsCipherSuites       []uint16
+	clientCAs             *x509.CertPool
+}
+
+// ParseWebHookOptions only parses the command line params, e.g. for the subcommands which do not serve
+func ParseWebHookOptions() *WebHookOptions {
+	wo := &WebHookOptions{}
+	wo.init()
+	return wo
 }
 
 // NewWebHookOptions parse the command line params and initialize the server
 func NewWebHookOptions() (options *WebHookOptions, err error) {
-	wo := &WebHookOptions{}
 	// initialize the flag parse
-	wo.init()
+	wo := ParseWebHookOptions()
+	if wo.Cleanup {
+		return wo, nil
+	}
 
 	//
 	err = wo.generateCert()
@@ -55,51 +120,208 @@ func (wo *WebHookOptions) init() {
 	flag.Var(&wo.Plugins, "plugins", "The configuration of plugins.")
 
 	flag.StringVar(&wo.WebhookCertDir, "webhook-server-certs-dir", "/run/secrets/tls/", "Path to the X.509-formatted webhook certificate.")
+	flag.StringVar(&wo.CertWriterType, "cert-writer", "", "How the certs are provisioned: fs generates them per replica, secret shares them by a secret, external reads the mounted certs (e.g. from cert-manager). Defaults to secret with --leaderElection and fs otherwise, fs can not be used with --leaderElection.")
+	flag.StringVar(&wo.CertSecretName, "cert-secret-name", "kubernetes-faketime-injector-certs", "The secret storing the certs when --cert-writer=secret.")
+	flag.StringVar(&wo.CertSecretNamespace, "cert-secret-namespace", "", "The namespace of the cert secret, defaults to the service namespace.")
+	flag.StringVar(&wo.CertKeyAlgorithm, "cert-key-algorithm", generator.KeyAlgorithmRSA2048, "The key algorithm of the self signed certs: rsa2048, rsa4096, ecdsa-p256 or ecdsa-p384.")
+	flag.DurationVar(&wo.CAValidity, "ca-validity", generator.DefaultValidity, "The lifetime of the self signed CA certificate.")
+	flag.DurationVar(&wo.CertValidity, "cert-validity", generator.DefaultValidity, "The lifetime of the self signed serving certificate.")
+	flag.DurationVar(&wo.CertRenewBefore, "cert-renew-before", 182*24*time.Hour, "Regenerate the self signed certs if they expire within this duration.")
+	flag.StringVar(&wo.CertExtraSANs, "cert-extra-sans", "", "Comma separated extra DNS names or IPs of the serving certificate.")
+	flag.StringVar(&wo.TLSMinVersion, "tls-min-version", "1.2", "The minimum TLS version of the webhook server: 1.0, 1.1, 1.2 or 1.3.")
+	flag.StringVar(&wo.TLSCipherSuites, "tls-cipher-suites", "", "Comma separated TLS cipher suites of the webhook server, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Defaults to the Go defaults.")
+	flag.StringVar(&wo.ClientCAFile, "client-ca-file", "", "If set, callers must present a client certificate signed by this CA, e.g. the api server configured by an AdmissionConfiguration kubeconfig.")
+	flag.StringVar(&wo.ClientCertCommonNames, "client-cert-common-names", "", "Comma separated common names allowed in the client certificate, requires --client-ca-file.")
+	flag.DurationVar(&wo.CertCheckInterval, "cert-check-interval", time.Hour, "How often the certificate is checked and rotated before it expires.")
 	flag.StringVar(&wo.ServiceName, "service-name", "kubernetes-faketime-injector", "The service of kubernetes-webhook-injector.")
 	flag.StringVar(&wo.ServiceNamespace, "service-namespace", "kube-system", "The namespace of kubernetes-webhook-injector.")
 	flag.StringVar(&wo.Port, "port", "443", "The webhook service port of kubernetes-webhook-injector.")
+	flag.StringVar(&wo.MetricsPort, "metrics-port", "8080", "The plain http port of the /metrics endpoint.")
+	flag.StringVar(&wo.HealthPort, "health-port", "8081", "The plain http port of the /healthz and /readyz endpoints.")
+	flag.DurationVar(&wo.ShutdownDrainPeriod, "shutdown-drain-period", 5*time.Second, "How long the readiness fails before the servers are shut down.")
+	flag.DurationVar(&wo.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "How long to wait for in-flight requests during shutdown.")
 
+	flag.BoolVar(&wo.MutateWorkloads, "mutate-workloads", false, "Also mutate the pod templates of Deployments, StatefulSets, DaemonSets, Jobs, CronJobs and GameServerSets, so that the injected spec is visible in the workload.")
+	flag.BoolVar(&wo.ValidatingWebhook, "validating-webhook", true, "Register a ValidatingWebhookConfiguration rejecting the pods and workloads whose fake time annotations are invalid.")
+	flag.BoolVar(&wo.ValidationWarnOnly, "validation-warn-only", false, "Admit the pods and workloads with invalid fake time annotations with warnings instead of rejecting them.")
+	flag.StringVar(&wo.ValidationWarnNamespaces, "validation-warn-namespaces", "", "Comma separated namespaces where invalid fake time annotations are only warned about.")
+	flag.StringVar(&wo.InjectionAuthorization, "injection-authorization", "", "If set, the requesters setting cloudnativegame.io annotations must be allowed to inject faketimes.cloudnativegame.io in the namespace: deny rejects the others, skip admits their pods without fake time. Objects copying the annotations from the template of their controller are authorized by it. Registers a fail-closed webhook for all resources carrying pod templates.")
+	flag.StringVar(&wo.AuditLog, "audit-log", "", "Write every admission decision on objects with cloudnativegame.io annotations, and every failed request, as a JSON line to this file, - for stdout. Disabled if empty.")
+	flag.BoolVar(&wo.AuditLogAll, "audit-log-all", false, "Also write the admission decisions on objects without cloudnativegame.io annotations to the audit log, i.e. every pod and workload written in the cluster, which multiplies its volume.")
+	flag.IntVar(&wo.AuditLogMaxSize, "audit-log-max-size", 100, "The size in megabytes after which the audit log file is rotated, 0 disables the rotation.")
+	flag.IntVar(&wo.AuditLogMaxBackups, "audit-log-max-backups", 5, "The number of rotated audit log files to keep.")
+	flag.BoolVar(&wo.Cleanup, "cleanup", false, "Delete the MutatingWebhookConfiguration, the ValidatingWebhookConfiguration and the cert secret owned by this install and exit, e.g. from an uninstall job.")
 	flag.StringVar(&wo.KubeConf, "kubeconf", "", "use ~/.kube/conf as default.")
-	// todo enable leader election to support high performance
-	flag.BoolVar(&wo.LeaderElection, "leaderElection", true, "Enable leaderElection or not.")
+	flag.BoolVar(&wo.LeaderElection, "leaderElection", true, "Enable leaderElection or not. Only the leader registers the webhook, provisions the certs shared by --cert-writer=secret and removes the expired cluster mode anchors, which are shared by a ConfigMap, all replicas serve admission requests.")
+	flag.StringVar(&wo.LeaderElectionNamespace, "leader-election-namespace", "", "The namespace of the leader election lease, defaults to the service namespace.")
+	flag.StringVar(&wo.LeaderElectionID, "leader-election-id", "fake-time-injector-leader", "The name of the leader election lease.")
 	log.InitFlags(flag.CommandLine)
 
 	flag.Parse()
+
+	if wo.LeaderElectionNamespace == "" {
+		wo.LeaderElectionNamespace = wo.ServiceNamespace
+	}
+	if wo.CertSecretNamespace == "" {
+		wo.CertSecretNamespace = wo.ServiceNamespace
+	}
+	if wo.CertWriterType == "" {
+		// the replicas electing a leader serve with the certs provisioned by it
+		wo.CertWriterType = writer.FsCertWriter
+		if wo.LeaderElection {
+			wo.CertWriterType = writer.SecretCertWriter
+		}
+	}
 }
 
 func (wo *WebHookOptions) generateCert() error {
 	wo.DnsName = generator.ServiceToCommonName(wo.ServiceNamespace, wo.ServiceName)
-	var certWriter writer.CertWriter
 	var err error
 
-	certWriter, err = writer.NewFSCertWriter(writer.FSCertWriterOptions{Path: wo.WebhookCertDir})
+	if err = wo.validCertOptions(); err != nil {
+		return err
+	}
+	certGenerator := &generator.SelfSignedCertGenerator{
+		KeyAlgorithm:  wo.CertKeyAlgorithm,
+		CAValidity:    wo.CAValidity,
+		CertValidity:  wo.CertValidity,
+		CARenewBefore: wo.CertRenewBefore,
+	}
+	for _, san := range strings.Split(wo.CertExtraSANs, ",") {
+		if san = strings.TrimSpace(san); san != "" {
+			certGenerator.ExtraSANs = append(certGenerator.ExtraSANs, san)
+		}
+	}
+
+	switch wo.CertWriterType {
+	case writer.FsCertWriter:
+		wo.CertWriter, err = writer.NewFSCertWriter(writer.FSCertWriterOptions{
+			Path:          wo.WebhookCertDir,
+			CertGenerator: certGenerator,
+			RenewBefore:   wo.CertRenewBefore,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to constructs FSCertWriter: %v", err)
+		}
+	case writer.SecretCertWriter:
+		config, err := clientcmd.BuildConfigFromFlags("", wo.KubeConf)
+		if err != nil {
+			return err
+		}
+		clientSet, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+		wo.CertWriter, err = writer.NewSecretCertWriter(writer.SecretCertWriterOptions{
+			Clientset:     clientSet,
+			CertGenerator: certGenerator,
+			Secret:        &types.NamespacedName{Namespace: wo.CertSecretNamespace, Name: wo.CertSecretName},
+			RenewBefore:   wo.CertRenewBefore,
+			Labels:        ownerLabels(wo),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to constructs SecretCertWriter: %v", err)
+		}
+	case writer.ExternalCertWriter:
+		wo.CertWriter, err = writer.NewExternalCertWriter(writer.ExternalCertWriterOptions{Path: wo.WebhookCertDir})
+		if err != nil {
+			return fmt.Errorf("failed to constructs ExternalCertWriter: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown cert writer %s", wo.CertWriterType)
+	}
+
+	// the shared certs are provisioned by the leader, which is elected after the server has started
+	if wo.sharedCerts() {
+		certs, err := wo.loadCert()
+		if err != nil {
+			log.Warningf("Certs are not provisioned by the leader yet,because of %v", err)
+			return nil
+		}
+		wo.CaCert = certs
+		return nil
+	}
+	certs, _, err := wo.ensureCert()
 	if err != nil {
-		return fmt.Errorf("failed to constructs FSCertWriter: %v", err)
+		return err
 	}
+	wo.CaCert = certs
+	return nil
+}
 
-	certs, _, err := certWriter.EnsureCert(wo.DnsName)
+// sharedCerts returns true if the certs are provisioned by the leader in the secret and loaded by the other replicas
+func (wo *WebHookOptions) sharedCerts() bool {
+	return wo.LeaderElection && wo.CertWriterType == writer.SecretCertWriter
+}
+
+// validCertOptions rejects options which would regenerate the certs on every check, or serve with different CAs
+func (wo *WebHookOptions) validCertOptions() error {
+	// every replica would generate its own CA, only the one of the leader is registered
+	if wo.LeaderElection && wo.CertWriterType == writer.FsCertWriter {
+		return errors.New("the fs cert writer generates a CA per replica, use --cert-writer=secret or external with --leaderElection, or disable it for a single replica")
+	}
+	if !generator.ValidKeyAlgorithm(wo.CertKeyAlgorithm) {
+		return fmt.Errorf("unsupported cert key algorithm %s", wo.CertKeyAlgorithm)
+	}
+	if wo.CertRenewBefore <= 0 {
+		return errors.New("cert renew before must be positive")
+	}
+	if wo.CertValidity <= wo.CertRenewBefore || wo.CAValidity <= wo.CertRenewBefore {
+		return fmt.Errorf("cert validity %v and CA validity %v must be longer than cert renew before %v", wo.CertValidity, wo.CAValidity, wo.CertRenewBefore)
+	}
+	return nil
+}
+
+// ensureCert provisions the certs if they are missing or expire soon, and writes them to the cert dir
+func (wo *WebHookOptions) ensureCert() (*generator.Artifacts, bool, error) {
+	certs, changed, err := wo.CertWriter.EnsureCert(wo.DnsName)
 	if err != nil {
-		return fmt.Errorf("failed to ensure certs: %v", err)
+		return nil, false, fmt.Errorf("failed to ensure certs: %v", err)
+	}
+	// the mounted certs are managed by the external tool
+	if wo.CertWriterType == writer.ExternalCertWriter {
+		return certs, changed, nil
 	}
 
 	if err := writer.WriteCertsToDir(wo.WebhookCertDir, certs); err != nil {
-		return fmt.Errorf("failed to write certs to dir: %v", err)
+		return nil, false, fmt.Errorf("failed to write certs to dir: %v", err)
 	}
-	wo.CaCert = certs
-	return nil
+	return certs, changed, nil
+}
+
+// loadCert reads the certs provisioned by the leader, and writes them to the cert dir
+func (wo *WebHookOptions) loadCert() (*generator.Artifacts, error) {
+	certs, err := wo.CertWriter.LoadCert(wo.DnsName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certs: %v", err)
+	}
+	if err := writer.WriteCertsToDir(wo.WebhookCertDir, certs); err != nil {
+		return nil, fmt.Errorf("failed to write certs to dir: %v", err)
+	}
+	return certs, nil
 }
 
 // check params is valid or not
 func (wo *WebHookOptions) valid() (passed bool, msg string) {
 
-	pair, err := tls.X509KeyPair(wo.CaCert.Cert, wo.CaCert.Key)
-	if err != nil {
-		return false, fmt.Sprintf("Failed to parse certificate,because of %v", err)
+	// the shared certs may not be provisioned yet, they are loaded by the cert rotator
+	if wo.CaCert != nil {
+		pair, err := tls.X509KeyPair(wo.CaCert.Cert, wo.CaCert.Key)
+		if err != nil {
+			return false, fmt.Sprintf("Failed to parse certificate,because of %v", err)
+		}
+		wo.TLSPair = pair
 	}
-	wo.TLSPair = pair
 
-	// todo add other validations
-	// code block
+	if err := wo.parseTLSOptions(); err != nil {
+		return false, fmt.Sprintf("Failed to parse tls options,because of %v", err)
+	}
+
+	switch wo.InjectionAuthorization {
+	case "", AuthorizationDeny, AuthorizationSkip:
+	default:
+		return false, fmt.Sprintf("Unknown injection authorization %s, must be %s or %s", wo.InjectionAuthorization, AuthorizationDeny, AuthorizationSkip)
+	}
 
 	return true, ""
 }
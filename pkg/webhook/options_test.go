@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/writer"
+)
+
+func TestCertWriterWithLeaderElection(t *testing.T) {
+	tests := []struct {
+		leaderElection bool
+		certWriter     string
+		valid          bool
+	}{
+		{leaderElection: true, certWriter: writer.SecretCertWriter, valid: true},
+		{leaderElection: true, certWriter: writer.ExternalCertWriter, valid: true},
+		{leaderElection: true, certWriter: writer.FsCertWriter, valid: false},
+		{leaderElection: false, certWriter: writer.FsCertWriter, valid: true},
+	}
+	for _, tt := range tests {
+		wo := &WebHookOptions{
+			LeaderElection:   tt.leaderElection,
+			CertWriterType:   tt.certWriter,
+			CertKeyAlgorithm: generator.KeyAlgorithmRSA2048,
+			CAValidity:       generator.DefaultValidity,
+			CertValidity:     generator.DefaultValidity,
+			CertRenewBefore:  24 * time.Hour,
+		}
+		if err := wo.validCertOptions(); (err == nil) != tt.valid {
+			t.Errorf("leader election %v with %s: expected valid %v, got %v", tt.leaderElection, tt.certWriter, tt.valid, err)
+		}
+	}
+}
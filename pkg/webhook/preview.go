@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/plugins"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	"io"
+	addmissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// Preview runs the plugins against the pods and workloads of a multi-document YAML or JSON manifest,
+// and writes the mutated manifest with the JSON patch of every object as comments.
+func Preview(wo *WebHookOptions, in io.Reader, out io.Writer) error {
+	pluginManager := plugins.NewPluginManager()
+	defer pluginManager.Stop()
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	first := true
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %v", err)
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return fmt.Errorf("failed to decode manifest: %v", err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(out, "---")
+		}
+		first = false
+		if err := previewObject(wo, pluginManager, obj, out); err != nil {
+			return err
+		}
+	}
+}
+
+// previewObject writes the patch and the mutated object, objects which are neither pods nor workloads are written unchanged
+func previewObject(wo *WebHookOptions, pluginManager *plugins.PluginManager, obj map[string]interface{}, out io.Writer) error {
+	u := &unstructured.Unstructured{Object: obj}
+	name := fmt.Sprintf("%s %s", u.GetKind(), u.GetName())
+	if u.GetNamespace() != "" {
+		name = fmt.Sprintf("%s %s/%s", u.GetKind(), u.GetNamespace(), u.GetName())
+	}
+
+	patchBytes, results, err := previewPatch(wo, pluginManager, u, name, out)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		fmt.Fprintf(out, "# %s %s: %s\n", result.Plugin, result.Reason, result.Message)
+	}
+	if patchBytes != nil {
+		var ops []utils.PatchOperation
+		if err := json.Unmarshal(patchBytes, &ops); err != nil {
+			return err
+		}
+		indented, err := json.MarshalIndent(ops, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "# JSON patch of %s:\n", name)
+		for _, line := range strings.Split(string(indented), "\n") {
+			fmt.Fprintf(out, "# %s\n", line)
+		}
+		for _, op := range ops {
+			if obj, err = applyPatchOperation(obj, op); err != nil {
+				return fmt.Errorf("failed to apply the patch of %s: %v", name, err)
+			}
+		}
+	}
+
+	mutated, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(mutated)
+	return err
+}
+
+// previewPatch returns the patch of a pod, or of the pod template of a workload
+func previewPatch(wo *WebHookOptions, pluginManager *plugins.PluginManager, u *unstructured.Unstructured, name string, out io.Writer) ([]byte, []utils.PatchResult, error) {
+	if u.GetKind() == "Pod" && u.GetAPIVersion() == "v1" {
+		pod := &v1.Pod{}
+		raw, err := json.Marshal(u.Object)
+		if err == nil {
+			err = json.Unmarshal(raw, pod)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %v", name, err)
+		}
+		return pluginManager.HandlePatchPod(pod, addmissionV1.Create)
+	}
+
+	w, ok := matchWorkloadKind(u.GetAPIVersion(), u.GetKind())
+	if !ok {
+		fmt.Fprintf(out, "# %s is not mutated\n", name)
+		return nil, nil, nil
+	}
+	template, found, err := podTemplateOf(u.Object, w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode the pod template of %s: %v", name, err)
+	}
+	if !found {
+		return nil, nil, nil
+	}
+	if !wo.MutateWorkloads {
+		fmt.Fprintf(out, "# %s is not mutated without --mutate-workloads, its pods get the patch of the template\n", name)
+	}
+	return pluginManager.HandlePatchPodTemplate(template, u.GetNamespace(), w.templatePointer())
+}
+
+// applyPatchOperation applies an add, replace or remove operation of a json patch to the decoded object
+func applyPatchOperation(obj map[string]interface{}, op utils.PatchOperation) (map[string]interface{}, error) {
+	if !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("invalid path %q", op.Path)
+	}
+	var tokens []string
+	for _, token := range strings.Split(op.Path[1:], "/") {
+		tokens = append(tokens, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+	patched, err := patchAt(obj, tokens, op)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", op.Op, op.Path, err)
+	}
+	return patched.(map[string]interface{}), nil
+}
+
+func patchAt(node interface{}, tokens []string, op utils.PatchOperation) (interface{}, error) {
+	key := tokens[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(tokens) == 1 {
+			switch op.Op {
+			case "add", "replace":
+				n[key] = op.Value
+			case "remove":
+				delete(n, key)
+			default:
+				return nil, fmt.Errorf("unsupported operation")
+			}
+			return n, nil
+		}
+		child, ok := n[key]
+		if !ok {
+			return nil, fmt.Errorf("%s is not found", key)
+		}
+		child, err := patchAt(child, tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = child
+		return n, nil
+	case []interface{}:
+		if key == "-" && len(tokens) == 1 && op.Op == "add" {
+			return append(n, op.Value), nil
+		}
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx > len(n) || (idx == len(n) && !(len(tokens) == 1 && op.Op == "add")) {
+			return nil, fmt.Errorf("invalid index %s", key)
+		}
+		if len(tokens) == 1 {
+			switch op.Op {
+			case "add":
+				n = append(n, nil)
+				copy(n[idx+1:], n[idx:])
+				n[idx] = op.Value
+			case "replace":
+				n[idx] = op.Value
+			case "remove":
+				n = append(n[:idx], n[idx+1:]...)
+			default:
+				return nil, fmt.Errorf("unsupported operation")
+			}
+			return n, nil
+		}
+		child, err := patchAt(n[idx], tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%s is not an object or array", key)
+	}
+}
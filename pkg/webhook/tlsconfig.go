@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSOptions validates the tls flags and keeps the parsed values in the options
+func (wo *WebHookOptions) parseTLSOptions() error {
+	version, ok := tlsVersions[wo.TLSMinVersion]
+	if !ok {
+		return fmt.Errorf("unsupported tls min version %s", wo.TLSMinVersion)
+	}
+	wo.tlsMinVersion = version
+
+	wo.tlsCipherSuites = nil
+	if wo.TLSCipherSuites != "" {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(wo.TLSCipherSuites, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return fmt.Errorf("unsupported tls cipher suite %s", name)
+			}
+			wo.tlsCipherSuites = append(wo.tlsCipherSuites, id)
+		}
+	}
+
+	wo.clientCAs = nil
+	if wo.ClientCAFile != "" {
+		caCert, err := ioutil.ReadFile(wo.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("no valid certificate in client CA file %s", wo.ClientCAFile)
+		}
+		wo.clientCAs = pool
+	} else if wo.ClientCertCommonNames != "" {
+		return errors.New("client cert common names require a client CA file")
+	}
+	return nil
+}
+
+// tlsConfig builds the tls config of the webhook server
+func (wo *WebHookOptions) tlsConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     wo.tlsMinVersion,
+		CipherSuites:   wo.tlsCipherSuites,
+	}
+	if wo.clientCAs == nil {
+		return config
+	}
+
+	// only callers presenting a certificate signed by the client CA, e.g. the api server, may call the webhook
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = wo.clientCAs
+	if wo.ClientCertCommonNames != "" {
+		allowed := make(map[string]bool)
+		for _, name := range strings.Split(wo.ClientCertCommonNames, ",") {
+			allowed[strings.TrimSpace(name)] = true
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("no client certificate")
+			}
+			if cn := state.PeerCertificates[0].Subject.CommonName; !allowed[cn] {
+				return fmt.Errorf("client certificate common name %s is not allowed", cn)
+			}
+			return nil
+		}
+	}
+	return config
+}
@@ -0,0 +1,51 @@
+package fake
+
+import (
+	"testing"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+)
+
+func TestCertGenerator(t *testing.T) {
+	tests := []struct {
+		name       string
+		commonName string
+		caKey      []byte
+		caCert     []byte
+		found      bool
+		expectCA   string
+	}{
+		{name: "unknown common name", commonName: "other.ns.svc"},
+		{name: "without CA", commonName: "svc.ns.svc", found: true, expectCA: "ca-cert"},
+		{name: "with CA", commonName: "svc.ns.svc", caKey: []byte("new-ca-key"), caCert: []byte("new-ca-cert"), found: true, expectCA: "new-ca-cert"},
+		{name: "with invalid CA", commonName: "svc.ns.svc", caKey: []byte("invalid-ca-key"), caCert: []byte("new-ca-cert"), found: true, expectCA: "ca-cert"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cp generator.CertGenerator = &CertGenerator{
+				DNSNameToCertArtifacts: map[string]*generator.Artifacts{
+					"svc.ns.svc": {Key: []byte("key"), Cert: []byte("cert"), CAKey: []byte("ca-key"), CACert: []byte("ca-cert")},
+				},
+			}
+			if tt.caKey != nil {
+				cp.SetCA(tt.caKey, tt.caCert)
+			}
+			certs, err := cp.Generate(tt.commonName)
+			if !tt.found {
+				if err == nil {
+					t.Errorf("expected an error for %s", tt.commonName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(certs.Cert) != "cert" || string(certs.Key) != "key" {
+				t.Errorf("expected the configured serving cert, got %s", certs.Cert)
+			}
+			if string(certs.CACert) != tt.expectCA {
+				t.Errorf("expected CA %s, got %s", tt.expectCA, certs.CACert)
+			}
+		})
+	}
+}
@@ -19,6 +19,8 @@ package generator
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -37,6 +39,15 @@ import (
 
 const (
 	rsaKeySize = 2048
+
+	// KeyAlgorithmRSA2048 is the default key algorithm
+	KeyAlgorithmRSA2048   = "rsa2048"
+	KeyAlgorithmRSA4096   = "rsa4096"
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+
+	// DefaultValidity is the default lifetime of the CA and serving certificates
+	DefaultValidity = time.Hour * 24 * 365 * 10
 )
 
 // ServiceToCommonName generates the CommonName for the certificate when using a k8s service.
@@ -49,6 +60,17 @@ func ServiceToCommonName(serviceNamespace, serviceName string) string {
 type SelfSignedCertGenerator struct {
 	caKey  []byte
 	caCert []byte
+
+	// KeyAlgorithm is one of rsa2048, rsa4096, ecdsa-p256 and ecdsa-p384, defaults to rsa2048.
+	KeyAlgorithm string
+	// CAValidity is the lifetime of a new CA certificate, defaults to 10 years.
+	CAValidity time.Duration
+	// CertValidity is the lifetime of a new serving certificate, defaults to 10 years.
+	CertValidity time.Duration
+	// CARenewBefore regenerates the CA if it expires within this duration, defaults to 1 year.
+	CARenewBefore time.Duration
+	// ExtraSANs are additional DNS names or IPs of the serving certificate.
+	ExtraSANs []string
 }
 
 var _ CertGenerator = &SelfSignedCertGenerator{}
@@ -63,17 +85,17 @@ func (cp *SelfSignedCertGenerator) SetCA(caKey, caCert []byte) {
 // key for the server. serverKey and serverCert are used by the server
 // to establish trust for clients, CA certificate is used by the
 // client to verify the server authentication chain.
-// The cert will be valid for 365 days.
+// The cert will be valid for CertValidity, but never longer than the CA.
 func (cp *SelfSignedCertGenerator) Generate(commonName string) (*Artifacts, error) {
 	var err error
 
 	valid, signingKey, signingCert := cp.validCACert()
 	if !valid {
-		signingKey, err = NewPrivateKey()
+		signingKey, err = NewPrivateKeyWithAlgorithm(cp.KeyAlgorithm)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create the CA private key: %v", err)
 		}
-		signingCert, err = cert.NewSelfSignedCACert(cert.Config{CommonName: "webhook-cert-ca"}, signingKey)
+		signingCert, err = NewSelfSignedCACert(cert.Config{CommonName: "webhook-cert-ca"}, signingKey, cp.caValidity())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create the CA cert: %v", err)
 		}
@@ -87,32 +109,68 @@ func (cp *SelfSignedCertGenerator) Generate(commonName string) (*Artifacts, erro
 	} else {
 		DNSNames = append(DNSNames, commonName)
 	}
+	for _, san := range cp.ExtraSANs {
+		if ip := net.ParseIP(san); ip != nil {
+			altIPs = append(altIPs, ip)
+		} else if san != "" {
+			DNSNames = append(DNSNames, san)
+		}
+	}
 
-	key, err := NewPrivateKey()
+	key, err := NewPrivateKeyWithAlgorithm(cp.KeyAlgorithm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create the private key: %v", err)
 	}
-	signedCert, err := NewSignedCert(
+	signedCert, err := NewSignedCertWithValidity(
 		cert.Config{
 			CommonName: commonName,
 			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 			AltNames:   cert.AltNames{IPs: altIPs, DNSNames: DNSNames},
 		},
-		key, signingCert, signingKey,
+		key, signingCert, signingKey, cp.certValidity(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create the cert: %v", err)
 	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the private key: %v", err)
+	}
+	caKeyPEM, err := keyutil.MarshalPrivateKeyToPEM(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the CA private key: %v", err)
+	}
 	return &Artifacts{
-		Key:    EncodePrivateKeyPEM(key),
+		Key:    keyPEM,
 		Cert:   EncodeCertPEM(signedCert),
-		CAKey:  EncodePrivateKeyPEM(signingKey),
+		CAKey:  caKeyPEM,
 		CACert: EncodeCertPEM(signingCert),
 	}, nil
 }
 
-func (cp *SelfSignedCertGenerator) validCACert() (bool, *rsa.PrivateKey, *x509.Certificate) {
-	if !ValidCACert(cp.caKey, cp.caCert, cp.caCert, "", time.Now().AddDate(1, 0, 0)) {
+func (cp *SelfSignedCertGenerator) caValidity() time.Duration {
+	if cp.CAValidity > 0 {
+		return cp.CAValidity
+	}
+	return DefaultValidity
+}
+
+func (cp *SelfSignedCertGenerator) certValidity() time.Duration {
+	if cp.CertValidity > 0 {
+		return cp.CertValidity
+	}
+	return DefaultValidity
+}
+
+func (cp *SelfSignedCertGenerator) caRenewBefore() time.Duration {
+	if cp.CARenewBefore > 0 {
+		return cp.CARenewBefore
+	}
+	return time.Hour * 24 * 365
+}
+
+func (cp *SelfSignedCertGenerator) validCACert() (bool, crypto.Signer, *x509.Certificate) {
+	if !ValidCACert(cp.caKey, cp.caCert, cp.caCert, "", time.Now().Add(cp.caRenewBefore())) {
 		return false, nil, nil
 	}
 
@@ -120,8 +178,8 @@ func (cp *SelfSignedCertGenerator) validCACert() (bool, *rsa.PrivateKey, *x509.C
 	if err != nil {
 		return false, nil, nil
 	}
-	privateKey, ok := key.(*rsa.PrivateKey)
-	if !ok {
+	privateKey, ok := key.(crypto.Signer)
+	if !ok || !matchKeyAlgorithm(privateKey, cp.KeyAlgorithm) {
 		return false, nil, nil
 	}
 
@@ -135,13 +193,87 @@ func (cp *SelfSignedCertGenerator) validCACert() (bool, *rsa.PrivateKey, *x509.C
 	return true, privateKey, certs[0]
 }
 
+// ValidKeyAlgorithm returns true if the key algorithm is supported
+func ValidKeyAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "", KeyAlgorithmRSA2048, KeyAlgorithmRSA4096, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384:
+		return true
+	}
+	return false
+}
+
+// matchKeyAlgorithm checks the existing key was created with the algorithm, so that a changed algorithm regenerates the CA
+func matchKeyAlgorithm(key crypto.Signer, algorithm string) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch algorithm {
+		case "", KeyAlgorithmRSA2048:
+			return k.N.BitLen() == 2048
+		case KeyAlgorithmRSA4096:
+			return k.N.BitLen() == 4096
+		}
+	case *ecdsa.PrivateKey:
+		switch algorithm {
+		case KeyAlgorithmECDSAP256:
+			return k.Curve == elliptic.P256()
+		case KeyAlgorithmECDSAP384:
+			return k.Curve == elliptic.P384()
+		}
+	}
+	return false
+}
+
 // NewPrivateKey creates an RSA private key
 func NewPrivateKey() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(cryptorand.Reader, rsaKeySize)
 }
 
+// NewPrivateKeyWithAlgorithm creates a private key of the algorithm, an empty algorithm creates an RSA 2048 key
+func NewPrivateKeyWithAlgorithm(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "", KeyAlgorithmRSA2048:
+		return NewPrivateKey()
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(cryptorand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+// NewSelfSignedCACert creates a CA certificate valid for the given duration
+func NewSelfSignedCACert(cfg cert.Config, key crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: new(big.Int).SetInt64(0),
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		DNSNames:              []string{cfg.CommonName},
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(validity).UTC(),
+		KeyUsage:              keyUsage(key) | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDERBytes)
+}
+
 // NewSignedCert creates a signed certificate using the given CA certificate and key
 func NewSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+	return NewSignedCertWithValidity(cfg, key, caCert, caKey, DefaultValidity)
+}
+
+// NewSignedCertWithValidity creates a signed certificate valid for the given duration, but never longer than the CA
+func NewSignedCertWithValidity(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
 	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
 		return nil, err
@@ -153,6 +285,10 @@ func NewSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate,
 		return nil, errors.New("must specify at least one ExtKeyUsage")
 	}
 
+	notAfter := time.Now().Add(validity).UTC()
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
 	certTmpl := x509.Certificate{
 		Subject: pkix.Name{
 			CommonName:   cfg.CommonName,
@@ -162,8 +298,8 @@ func NewSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate,
 		IPAddresses:  cfg.AltNames.IPs,
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
-		NotAfter:     time.Now().Add(time.Hour * 24 * 365 * 10).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:     notAfter,
+		KeyUsage:     keyUsage(key),
 		ExtKeyUsage:  cfg.Usages,
 	}
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
@@ -173,6 +309,14 @@ func NewSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate,
 	return x509.ParseCertificate(certDERBytes)
 }
 
+// keyUsage returns the key usage of the key, key encipherment only applies to RSA keys
+func keyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
 // EncodePrivateKeyPEM returns PEM-encoded private key data
 func EncodePrivateKeyPEM(key *rsa.PrivateKey) []byte {
 	block := pem.Block{
@@ -0,0 +1,252 @@
+package generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+)
+
+func TestSelfSignedCertGeneratorKeyAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		check     func(key interface{}) bool
+	}{
+		{algorithm: "", check: func(key interface{}) bool { k, ok := key.(*rsa.PublicKey); return ok && k.N.BitLen() == 2048 }},
+		{algorithm: KeyAlgorithmRSA2048, check: func(key interface{}) bool { k, ok := key.(*rsa.PublicKey); return ok && k.N.BitLen() == 2048 }},
+		{algorithm: KeyAlgorithmECDSAP256, check: func(key interface{}) bool { k, ok := key.(*ecdsa.PublicKey); return ok && k.Curve == elliptic.P256() }},
+		{algorithm: KeyAlgorithmECDSAP384, check: func(key interface{}) bool { k, ok := key.(*ecdsa.PublicKey); return ok && k.Curve == elliptic.P384() }},
+	}
+	for _, tt := range tests {
+		t.Run("algorithm "+tt.algorithm, func(t *testing.T) {
+			cp := &SelfSignedCertGenerator{KeyAlgorithm: tt.algorithm}
+			certs, err := cp.Generate("svc.ns.svc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tls.X509KeyPair(certs.Cert, certs.Key); err != nil {
+				t.Errorf("key and cert are not a pair: %v", err)
+			}
+			if _, err := tls.X509KeyPair(certs.CACert, certs.CAKey); err != nil {
+				t.Errorf("CA key and CA cert are not a pair: %v", err)
+			}
+			serving, ca := parseCert(t, certs.Cert), parseCert(t, certs.CACert)
+			if !tt.check(serving.PublicKey) || !tt.check(ca.PublicKey) {
+				t.Errorf("expected %q keys, got %T and %T", tt.algorithm, serving.PublicKey, ca.PublicKey)
+			}
+			_, isRSA := serving.PublicKey.(*rsa.PublicKey)
+			if hasEncipherment := serving.KeyUsage&x509.KeyUsageKeyEncipherment != 0; hasEncipherment != isRSA {
+				t.Errorf("key encipherment is %v for an RSA key %v", hasEncipherment, isRSA)
+			}
+		})
+	}
+
+	if ValidKeyAlgorithm("dsa") {
+		t.Error("dsa must not be a valid key algorithm")
+	}
+	if _, err := NewPrivateKeyWithAlgorithm("dsa"); err == nil {
+		t.Error("expected an error for an unsupported key algorithm")
+	}
+}
+
+func TestSelfSignedCertGeneratorChain(t *testing.T) {
+	cp := &SelfSignedCertGenerator{KeyAlgorithm: KeyAlgorithmECDSAP256}
+	certs, err := cp.Generate("svc.ns.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := parseCert(t, certs.CACert)
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Errorf("expected a CA cert which signs certs, got IsCA=%v KeyUsage=%v", ca.IsCA, ca.KeyUsage)
+	}
+	if !ValidCACert(certs.Key, certs.Cert, certs.CACert, "svc.ns.svc", time.Now()) {
+		t.Error("the serving cert is not verified by its CA")
+	}
+	if ValidCACert(certs.Key, certs.Cert, certs.CACert, "other.ns.svc", time.Now()) {
+		t.Error("the serving cert must not be valid for another name")
+	}
+
+	other, err := (&SelfSignedCertGenerator{KeyAlgorithm: KeyAlgorithmECDSAP256}).Generate("svc.ns.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ValidCACert(certs.Key, certs.Cert, other.CACert, "svc.ns.svc", time.Now()) {
+		t.Error("the serving cert must not be verified by another CA")
+	}
+	if ValidCACert(other.Key, certs.Cert, certs.CACert, "svc.ns.svc", time.Now()) {
+		t.Error("a cert with another key must not be valid")
+	}
+
+	// a valid CA is reused to sign the next serving cert
+	cp.SetCA(certs.CAKey, certs.CACert)
+	renewed, err := cp.Generate("svc.ns.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(renewed.CACert) != string(certs.CACert) {
+		t.Error("expected the CA to be reused")
+	}
+	if string(renewed.Cert) == string(certs.Cert) {
+		t.Error("expected a new serving cert")
+	}
+	if !ValidCACert(renewed.Key, renewed.Cert, certs.CACert, "svc.ns.svc", time.Now()) {
+		t.Error("the new serving cert is not verified by the reused CA")
+	}
+
+	// a CA of another key algorithm is regenerated
+	cp.KeyAlgorithm = KeyAlgorithmRSA2048
+	regenerated, err := cp.Generate("svc.ns.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(regenerated.CACert) == string(certs.CACert) {
+		t.Error("expected the CA to be regenerated after the key algorithm changed")
+	}
+}
+
+func TestSelfSignedCertGeneratorValidity(t *testing.T) {
+	tests := []struct {
+		name          string
+		caValidity    time.Duration
+		certValidity  time.Duration
+		caNotAfter    time.Duration
+		certNotAfter  time.Duration
+		renewBefore   time.Duration
+		caReusedAfter bool
+	}{
+		{name: "defaults", caNotAfter: DefaultValidity, certNotAfter: DefaultValidity, caReusedAfter: true},
+		{name: "short serving cert", caValidity: 24 * time.Hour * 30, certValidity: time.Hour, caNotAfter: 24 * time.Hour * 30, certNotAfter: time.Hour, renewBefore: time.Hour, caReusedAfter: true},
+		{name: "serving cert capped by the CA", caValidity: 2 * time.Hour, certValidity: 24 * time.Hour, caNotAfter: 2 * time.Hour, certNotAfter: 2 * time.Hour, renewBefore: time.Hour, caReusedAfter: true},
+		{name: "CA about to expire", caValidity: 2 * time.Hour, certValidity: time.Hour, caNotAfter: 2 * time.Hour, certNotAfter: time.Hour, renewBefore: 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &SelfSignedCertGenerator{
+				KeyAlgorithm:  KeyAlgorithmECDSAP256,
+				CAValidity:    tt.caValidity,
+				CertValidity:  tt.certValidity,
+				CARenewBefore: tt.renewBefore,
+			}
+			now := time.Now()
+			certs, err := cp.Generate("svc.ns.svc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, serving := parseCert(t, certs.CACert), parseCert(t, certs.Cert)
+			assertAround(t, "CA", ca.NotAfter, now.Add(tt.caNotAfter))
+			assertAround(t, "serving cert", serving.NotAfter, now.Add(tt.certNotAfter))
+			if serving.NotAfter.After(ca.NotAfter) {
+				t.Errorf("serving cert expires at %v after its CA at %v", serving.NotAfter, ca.NotAfter)
+			}
+
+			cp.SetCA(certs.CAKey, certs.CACert)
+			next, err := cp.Generate("svc.ns.svc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reused := string(next.CACert) == string(certs.CACert); reused != tt.caReusedAfter {
+				t.Errorf("expected the CA to be reused %v, got %v", tt.caReusedAfter, reused)
+			}
+		})
+	}
+}
+
+func TestSelfSignedCertGeneratorSANs(t *testing.T) {
+	tests := []struct {
+		name       string
+		commonName string
+		extraSANs  []string
+		dnsNames   []string
+		ips        []string
+	}{
+		{name: "service name", commonName: "svc.ns.svc", dnsNames: []string{"localhost", "svc.ns.svc"}},
+		{name: "ip", commonName: "10.0.0.1", dnsNames: []string{"localhost"}, ips: []string{"10.0.0.1"}},
+		{
+			name:       "extra SANs",
+			commonName: "svc.ns.svc",
+			extraSANs:  []string{"svc.ns.svc.cluster.local", "", "192.168.1.1", "::1"},
+			dnsNames:   []string{"localhost", "svc.ns.svc", "svc.ns.svc.cluster.local"},
+			ips:        []string{"192.168.1.1", "::1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &SelfSignedCertGenerator{KeyAlgorithm: KeyAlgorithmECDSAP256, ExtraSANs: tt.extraSANs}
+			certs, err := cp.Generate(tt.commonName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serving := parseCert(t, certs.Cert)
+			if serving.Subject.CommonName != tt.commonName {
+				t.Errorf("expected common name %s, got %s", tt.commonName, serving.Subject.CommonName)
+			}
+			if !equalStrings(serving.DNSNames, tt.dnsNames) {
+				t.Errorf("expected DNS names %v, got %v", tt.dnsNames, serving.DNSNames)
+			}
+			var ips []string
+			for _, ip := range serving.IPAddresses {
+				ips = append(ips, ip.String())
+			}
+			if !equalStrings(ips, tt.ips) {
+				t.Errorf("expected IPs %v, got %v", tt.ips, ips)
+			}
+			for _, name := range append(tt.dnsNames, tt.ips...) {
+				if !ValidCACert(certs.Key, certs.Cert, certs.CACert, name, time.Now()) {
+					t.Errorf("the serving cert is not valid for %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSignedCertRequiresCommonNameAndUsages(t *testing.T) {
+	key, err := NewPrivateKeyWithAlgorithm(KeyAlgorithmECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewSelfSignedCACert(cert.Config{CommonName: "ca"}, key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSignedCert(cert.Config{Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, key, ca, key); err == nil {
+		t.Error("expected an error without common name")
+	}
+	if _, err := NewSignedCert(cert.Config{CommonName: "svc", AltNames: cert.AltNames{IPs: []net.IP{net.ParseIP("10.0.0.1")}}}, key, ca, key); err == nil {
+		t.Error("expected an error without usages")
+	}
+}
+
+func parseCert(t *testing.T, data []byte) *x509.Certificate {
+	t.Helper()
+	certs, err := cert.ParseCertsPEM(data)
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("expected a PEM encoded cert, got %d certs: %v", len(certs), err)
+	}
+	return certs[0]
+}
+
+// assertAround allows a minute of skew, the certs are generated after now
+func assertAround(t *testing.T, subject string, got, expected time.Time) {
+	t.Helper()
+	if got.Before(expected.Add(-time.Minute)) || got.After(expected.Add(time.Minute)) {
+		t.Errorf("expected %s to expire at %v, got %v", subject, expected, got)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
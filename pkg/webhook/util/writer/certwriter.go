@@ -19,6 +19,7 @@ package writer
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"k8s.io/klog/v2"
@@ -43,11 +44,14 @@ const (
 type CertWriter interface {
 	// EnsureCert provisions the cert for the webhookClientConfig.
 	EnsureCert(dnsName string) (*generator.Artifacts, bool, error)
+	// LoadCert reads the cert provisioned by another replica without regenerating it.
+	LoadCert(dnsName string) (*generator.Artifacts, error)
 }
 
 // handleCommon ensures the given webhook has a proper certificate.
 // It uses the given certReadWriter to read and (or) write the certificate.
-func handleCommon(dnsName string, ch certReadWriter) (*generator.Artifacts, bool, error) {
+// The certificate is regenerated if it expires within renewBefore, zero means six months.
+func handleCommon(dnsName string, ch certReadWriter, renewBefore time.Duration) (*generator.Artifacts, bool, error) {
 	if len(dnsName) == 0 {
 		return nil, false, errors.New("dnsName should not be empty")
 	}
@@ -61,7 +65,7 @@ func handleCommon(dnsName string, ch certReadWriter) (*generator.Artifacts, bool
 	}
 
 	// Recreate the cert if it's invalid.
-	valid := validCert(certs, dnsName)
+	valid := validCert(certs, dnsName, renewBefore)
 	if !valid {
 		klog.Info("cert is invalid or expired, regenerating a new one")
 		certs, err = ch.overwrite(certs.ResourceVersion)
@@ -73,6 +77,21 @@ func handleCommon(dnsName string, ch certReadWriter) (*generator.Artifacts, bool
 	return certs, changed, nil
 }
 
+// loadCommon reads the certificate and verifies it is currently valid for dnsName.
+func loadCommon(dnsName string, ch certReadWriter) (*generator.Artifacts, error) {
+	if len(dnsName) == 0 {
+		return nil, errors.New("dnsName should not be empty")
+	}
+	certs, err := ch.read()
+	if err != nil {
+		return nil, err
+	}
+	if !generator.ValidCACert(certs.Key, certs.Cert, certs.CACert, dnsName, time.Now()) {
+		return nil, fmt.Errorf("certs are invalid or expired for %s", dnsName)
+	}
+	return certs, nil
+}
+
 func createIfNotExists(ch certReadWriter) (*generator.Artifacts, bool, error) {
 	// Try to read first
 	certs, err := ch.read()
@@ -98,10 +117,13 @@ type certReadWriter interface {
 	overwrite(resourceVersion string) (*generator.Artifacts, error)
 }
 
-func validCert(certs *generator.Artifacts, dnsName string) bool {
+func validCert(certs *generator.Artifacts, dnsName string, renewBefore time.Duration) bool {
 	if certs == nil {
 		return false
 	}
 	expired := time.Now().AddDate(0, 6, 0)
+	if renewBefore > 0 {
+		expired = time.Now().Add(renewBefore)
+	}
 	return generator.ValidCACert(certs.Key, certs.Cert, certs.CACert, dnsName, expired)
 }
@@ -0,0 +1,89 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
+)
+
+const (
+	ExternalCertWriter = "external"
+	// CACertName2 is the name of the CA certificate written by cert-manager
+	CACertName2 = "ca.crt"
+)
+
+// externalCertWriter reads the certificate provisioned by an external tool, e.g. cert-manager.
+// It never generates or writes certificates.
+type externalCertWriter struct {
+	*ExternalCertWriterOptions
+}
+
+// ExternalCertWriterOptions are options for constructing an externalCertWriter.
+type ExternalCertWriterOptions struct {
+	// path is the directory where the certificate, private key and CA certificate are mounted.
+	Path string
+}
+
+var _ CertWriter = &externalCertWriter{}
+
+func (ops *ExternalCertWriterOptions) validate() error {
+	if len(ops.Path) == 0 {
+		return errors.New("path must be set in ExternalCertWriterOptions")
+	}
+	return nil
+}
+
+// NewExternalCertWriter constructs a CertWriter that reads the mounted certificate.
+func NewExternalCertWriter(ops ExternalCertWriterOptions) (CertWriter, error) {
+	err := ops.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &externalCertWriter{ExternalCertWriterOptions: &ops}, nil
+}
+
+// EnsureCert reads the mounted certificates and verifies they are currently valid for dnsName.
+func (e *externalCertWriter) EnsureCert(dnsName string) (*generator.Artifacts, bool, error) {
+	certs, err := e.read()
+	if err != nil {
+		return nil, false, err
+	}
+	if !generator.ValidCACert(certs.Key, certs.Cert, certs.CACert, dnsName, time.Now()) {
+		return nil, false, fmt.Errorf("mounted certs in %s are invalid or expired for %s", e.Path, dnsName)
+	}
+	return certs, false, nil
+}
+
+// LoadCert reads the mounted certificates, they are never regenerated.
+func (e *externalCertWriter) LoadCert(dnsName string) (*generator.Artifacts, error) {
+	certs, _, err := e.EnsureCert(dnsName)
+	return certs, err
+}
+
+func (e *externalCertWriter) read() (*generator.Artifacts, error) {
+	certBytes, err := ioutil.ReadFile(path.Join(e.Path, ServerCertName2))
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := ioutil.ReadFile(path.Join(e.Path, ServerKeyName2))
+	if err != nil {
+		return nil, err
+	}
+	caCertBytes, err := ioutil.ReadFile(path.Join(e.Path, CACertName2))
+	if os.IsNotExist(err) {
+		caCertBytes, err = ioutil.ReadFile(path.Join(e.Path, CACertName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &generator.Artifacts{
+		CACert: caCertBytes,
+		Cert:   certBytes,
+		Key:    keyBytes,
+	}, nil
+}
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -48,6 +49,8 @@ type FSCertWriterOptions struct {
 	CertGenerator generator.CertGenerator
 	// path is the directory that the certificate and private key and CA certificate will be written.
 	Path string
+	// renewBefore regenerates the certificate if it expires within this duration, zero means six months.
+	RenewBefore time.Duration
 }
 
 var _ CertWriter = &fsCertWriter{}
@@ -79,7 +82,12 @@ func NewFSCertWriter(ops FSCertWriterOptions) (CertWriter, error) {
 func (f *fsCertWriter) EnsureCert(dnsName string) (*generator.Artifacts, bool, error) {
 	// create or refresh cert and write it to fs
 	f.dnsName = dnsName
-	return handleCommon(f.dnsName, f)
+	return handleCommon(f.dnsName, f, f.RenewBefore)
+}
+
+// LoadCert reads the certificates in the filesystem.
+func (f *fsCertWriter) LoadCert(dnsName string) (*generator.Artifacts, error) {
+	return loadCommon(dnsName, f)
 }
 
 func (f *fsCertWriter) write() (*generator.Artifacts, error) {
@@ -20,6 +20,7 @@ package writer
 import (
 	"context"
 	"errors"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -51,6 +52,10 @@ type SecretCertWriterOptions struct {
 	CertGenerator generator.CertGenerator
 	// secret points the secret that contains certificates that written by the CertWriter.
 	Secret *types.NamespacedName
+	// renewBefore regenerates the certificate if it expires within this duration, zero means six months.
+	RenewBefore time.Duration
+	// labels are set on the secret, so that it can be found and cleaned up.
+	Labels map[string]string
 }
 
 var _ CertWriter = &secretCertWriter{}
@@ -85,7 +90,12 @@ func NewSecretCertWriter(ops SecretCertWriterOptions) (CertWriter, error) {
 func (s *secretCertWriter) EnsureCert(dnsName string) (*generator.Artifacts, bool, error) {
 	// Create or refresh the certs based on clientConfig
 	s.dnsName = dnsName
-	return handleCommon(s.dnsName, s)
+	return handleCommon(s.dnsName, s, s.RenewBefore)
+}
+
+// LoadCert reads the certificates from the k8s secret written by another replica.
+func (s *secretCertWriter) LoadCert(dnsName string) (*generator.Artifacts, error) {
+	return loadCommon(dnsName, s)
 }
 
 var _ certReadWriter = &secretCertWriter{}
@@ -95,7 +105,7 @@ func (s *secretCertWriter) buildSecret() (*corev1.Secret, *generator.Artifacts,
 	if err != nil {
 		return nil, nil, err
 	}
-	secret := certsToSecret(certs, *s.Secret)
+	secret := certsToSecret(certs, *s.Secret, s.Labels)
 	return secret, certs, err
 }
 
@@ -157,7 +167,7 @@ func secretToCerts(secret *corev1.Secret) *generator.Artifacts {
 	return ret
 }
 
-func certsToSecret(certs *generator.Artifacts, sec types.NamespacedName) *corev1.Secret {
+func certsToSecret(certs *generator.Artifacts, sec types.NamespacedName, labels map[string]string) *corev1.Secret {
 	return &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
@@ -166,6 +176,7 @@ func certsToSecret(certs *generator.Artifacts, sec types.NamespacedName) *corev1
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: sec.Namespace,
 			Name:      sec.Name,
+			Labels:    labels,
 		},
 		Data: map[string][]byte{
 			CAKeyName:       certs.CAKey,
@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/plugins"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	"io"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// ManifestIssue is an annotation issue of an object in a manifest
+type ManifestIssue struct {
+	File      string `json:"file"`
+	Document  int    `json:"document"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	utils.ValidationIssue
+}
+
+// ValidationReport is the machine readable output of the validate subcommand
+type ValidationReport struct {
+	Errors   int             `json:"errors"`
+	Warnings int             `json:"warnings"`
+	Issues   []ManifestIssue `json:"issues"`
+}
+
+// ValidateManifest checks the cloudnativegame.io annotations of the pods and the pod templates of the workloads
+// in a multi-document YAML or JSON manifest, lists like the output of kustomize are checked item by item.
+func ValidateManifest(file string, in io.Reader) ([]ManifestIssue, error) {
+	pluginManager := plugins.NewPluginManager()
+	defer pluginManager.Stop()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	issues := make([]ManifestIssue, 0)
+	for document := 0; ; document++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return issues, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, fmt.Errorf("failed to decode document %d of %s: %v", document, file, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objectIssues, err := validateObject(pluginManager, &unstructured.Unstructured{Object: obj})
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate document %d of %s: %v", document, file, err)
+		}
+		for i := range objectIssues {
+			objectIssues[i].File = file
+			objectIssues[i].Document = document
+		}
+		issues = append(issues, objectIssues...)
+	}
+}
+
+func validateObject(pluginManager *plugins.PluginManager, u *unstructured.Unstructured) ([]ManifestIssue, error) {
+	if u.IsList() {
+		var issues []ManifestIssue
+		err := u.EachListItem(func(item runtime.Object) error {
+			itemIssues, err := validateObject(pluginManager, item.(*unstructured.Unstructured))
+			issues = append(issues, itemIssues...)
+			return err
+		})
+		return issues, err
+	}
+
+	var pod *v1.Pod
+	var issues []ManifestIssue
+	if u.GetKind() == "Pod" && u.GetAPIVersion() == "v1" {
+		pod = &v1.Pod{}
+		raw, err := json.Marshal(u.Object)
+		if err == nil {
+			err = json.Unmarshal(raw, pod)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if w, ok := matchWorkloadKind(u.GetAPIVersion(), u.GetKind()); ok {
+		// the annotations of the workload itself are not copied to its pods
+		var keys []string
+		for key := range u.GetAnnotations() {
+			if strings.HasPrefix(key, utils.AnnotationPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			issues = append(issues, manifestIssue(u, utils.ValidationIssue{Annotation: key, Severity: utils.SeverityWarning,
+				Message: fmt.Sprintf("has no effect on the %s, set it in the pod template", u.GetKind())}))
+		}
+		template, found, err := podTemplateOf(u.Object, w)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			pod = &v1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+		}
+	}
+	if pod == nil {
+		return issues, nil
+	}
+	for _, issue := range pluginManager.ValidatePod(pod) {
+		issues = append(issues, manifestIssue(u, issue))
+	}
+	return issues, nil
+}
+
+func manifestIssue(u *unstructured.Unstructured, issue utils.ValidationIssue) ManifestIssue {
+	return ManifestIssue{
+		Kind:            u.GetKind(),
+		Namespace:       u.GetNamespace(),
+		Name:            u.GetName(),
+		ValidationIssue: issue,
+	}
+}
+
+// NewValidationReport counts the errors and warnings of the issues
+func NewValidationReport(issues []ManifestIssue) *ValidationReport {
+	report := &ValidationReport{Issues: make([]ManifestIssue, 0, len(issues))}
+	report.Issues = append(report.Issues, issues...)
+	for _, issue := range issues {
+		if issue.Severity == utils.SeverityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	return report
+}
+
+// Write prints the report as json, or as one line per issue
+func (r *ValidationReport) Write(out io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case "text":
+		for _, issue := range r.Issues {
+			name := issue.Name
+			if issue.Namespace != "" {
+				name = issue.Namespace + "/" + issue.Name
+			}
+			fmt.Fprintf(out, "%s:%d: %s %s: %s: %s: %s\n", issue.File, issue.Document, issue.Kind, name, issue.Severity, issue.Annotation, issue.Message)
+		}
+		fmt.Fprintf(out, "%d errors, %d warnings\n", r.Errors, r.Warnings)
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %s, must be text or json", format)
+	}
+}
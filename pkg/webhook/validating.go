@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	addmissionV1 "k8s.io/api/admission/v1"
+	mutateV1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	log "k8s.io/klog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ValidatingWebhookConfigurationName = "kubernetes-faketime-injector"
+	ValidatingWebhookConfigurationPath = "/validate"
+)
+
+// validate rejects the pods and workloads whose annotations fail the validation of the plugins,
+// the namespaces configured as warn-only admit them with warnings
+func (ws *WebHookServer) validate(ar *addmissionV1.AdmissionReview) *addmissionV1.AdmissionResponse {
+	req := ar.Request
+	pod, err := podOfRequest(req.Object.Raw, req.Resource)
+	if err != nil {
+		log.Errorf("Failed to decode %s %s/%s,because of %v", req.Resource.Resource, req.Namespace, req.Name, err)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+		return errorResponse(fmt.Sprintf("failed to decode the %s: %v", req.Resource.Resource, err))
+	}
+	if pod == nil {
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+		return &addmissionV1.AdmissionResponse{Allowed: true}
+	}
+	// the namespace is not set in the objects created by controllers and in pod templates
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+	if req.Operation == addmissionV1.Update {
+		// objects created before must stay updatable, e.g. to remove their finalizers, unless the annotations are changed
+		oldPod, err := podOfRequest(req.OldObject.Raw, req.Resource)
+		if err == nil && oldPod != nil && reflect.DeepEqual(injectorAnnotations(oldPod), injectorAnnotations(pod)) {
+			metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+			return &addmissionV1.AdmissionResponse{Allowed: true}
+		}
+	}
+
+	var violations, warnings []string
+	for _, issue := range ws.pluginManager.ValidatePod(pod) {
+		message := fmt.Sprintf("%s: %s", issue.Annotation, issue.Message)
+		if issue.Severity == utils.SeverityError {
+			violations = append(violations, message)
+		} else {
+			warnings = append(warnings, message)
+		}
+	}
+	if len(violations) == 0 {
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+		return &addmissionV1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+
+	if ws.Options.validationWarnOnly(req.Namespace) {
+		log.Warningf("Admit %s %s/%s with invalid fake time annotations in warn-only namespace: %s", req.Kind.Kind, req.Namespace, req.Name, strings.Join(violations, "; "))
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+		return &addmissionV1.AdmissionResponse{Allowed: true, Warnings: append(violations, warnings...)}
+	}
+	log.Infof("Deny %s %s/%s with invalid fake time annotations: %s", req.Kind.Kind, req.Namespace, req.Name, strings.Join(violations, "; "))
+	metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeDenied).Inc()
+	return &addmissionV1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: "invalid fake time annotations: " + strings.Join(violations, "; "),
+		},
+		Warnings: warnings,
+	}
+}
+
+// podOfRequest decodes a pod, or the pod template of a workload, nil is returned for other resources
+func podOfRequest(raw []byte, resource metav1.GroupVersionResource) (*v1.Pod, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if resource.Group == "" && resource.Resource == "pods" {
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(raw, pod); err != nil {
+			return nil, err
+		}
+		return pod, nil
+	}
+	w, ok := matchWorkload(resource)
+	if !ok {
+		return nil, nil
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	template, found, err := podTemplateOf(obj, w)
+	if err != nil || !found {
+		return nil, err
+	}
+	pod := &v1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	// the owner of the workload authorizes its template
+	pod.OwnerReferences = (&unstructured.Unstructured{Object: obj}).GetOwnerReferences()
+	return pod, nil
+}
+
+// injectorAnnotations returns the cloudnativegame.io annotations of the pod
+func injectorAnnotations(pod *v1.Pod) map[string]string {
+	annotations := make(map[string]string)
+	for k, v := range pod.Annotations {
+		if strings.HasPrefix(k, utils.AnnotationPrefix) {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// validationWarnOnly returns true if invalid annotations are only warned about in the namespace
+func (wo *WebHookOptions) validationWarnOnly(namespace string) bool {
+	if wo.ValidationWarnOnly {
+		return true
+	}
+	for _, ns := range strings.Split(wo.ValidationWarnNamespaces, ",") {
+		if strings.TrimSpace(ns) == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// register ValidatingWebhookConfiguration with the validating and the authorizing webhooks, it is removed if both are disabled
+func (ws *WebHookServer) registerValidatingWebhookConfiguration() error {
+	if !ws.Options.ValidatingWebhook && ws.Options.InjectionAuthorization == "" {
+		return deleteValidatingWebhookConfiguration(ws.clientSet, ws.Options)
+	}
+	port, err := strconv.ParseInt(ws.Options.Port, 10, 32)
+	if err != nil {
+		return err
+	}
+	portInt32 := int32(port)
+	clientConfig := func(path *string) mutateV1.WebhookClientConfig {
+		return mutateV1.WebhookClientConfig{
+			Service: &mutateV1.ServiceReference{
+				Namespace: ws.Options.ServiceNamespace,
+				Name:      ws.Options.ServiceName,
+				Port:      &portInt32,
+				Path:      path,
+			},
+			CABundle: ws.caBundle(),
+		}
+	}
+
+	sideEffectClassNone := mutateV1.SideEffectClassNone
+	var webhook []mutateV1.ValidatingWebhook
+	if ws.Options.ValidatingWebhook {
+		rules := []mutateV1.RuleWithOperations{
+			{
+				Operations: []mutateV1.OperationType{mutateV1.Create, mutateV1.Update},
+				Rule: mutateV1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods"},
+				},
+			},
+		}
+		rules = append(rules, workloadRules()...)
+		ignore := mutateV1.Ignore
+		webhook = append(webhook, mutateV1.ValidatingWebhook{
+			Name:                    "validate." + ws.Options.DnsName,
+			SideEffects:             &sideEffectClassNone,
+			FailurePolicy:           &ignore,
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			ClientConfig:            clientConfig(&ValidatingWebhookConfigurationPath),
+			Rules:                   rules,
+		})
+	}
+	if ws.Options.InjectionAuthorization != "" {
+		// pods are authorized by the mutating webhook, a pod admitted while the injector is down is not injected.
+		// The workloads must not be admitted unchecked, their pods are injected later. The namespace of the
+		// injector is excluded, so that the injector itself can be recreated while it is down.
+		fail := mutateV1.Fail
+		webhook = append(webhook, mutateV1.ValidatingWebhook{
+			Name:                    "authorize." + ws.Options.DnsName,
+			SideEffects:             &sideEffectClassNone,
+			FailurePolicy:           &fail,
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			ClientConfig:            clientConfig(&AuthorizationPath),
+			Rules:                   authorizationRules(),
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpNotIn, Values: []string{ws.Options.ServiceNamespace}},
+				},
+			},
+		})
+	}
+
+	labels := ownerLabels(ws.Options)
+	vwc, err := ws.clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), ValidatingWebhookConfigurationName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		vwc = &mutateV1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   ValidatingWebhookConfigurationName,
+				Labels: labels,
+			},
+			Webhooks: webhook,
+		}
+		if _, err := ws.clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.TODO(), vwc, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create %s: %v", ValidatingWebhookConfigurationName, err)
+		}
+		log.Infof("ValidatingWebhookConfiguration %s has been created", ValidatingWebhookConfigurationName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	vwc.Webhooks = webhook
+	if vwc.Labels == nil {
+		vwc.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		vwc.Labels[k] = v
+	}
+	if _, err := ws.clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), vwc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update %s: %v", ValidatingWebhookConfigurationName, err)
+	}
+	log.Infof("ValidatingWebhookConfiguration %s has been updated", ValidatingWebhookConfigurationName)
+	return nil
+}
+
+// deleteValidatingWebhookConfiguration deletes the ValidatingWebhookConfiguration if it is owned by this install
+func deleteValidatingWebhookConfiguration(clientSet kubernetes.Interface, wo *WebHookOptions) error {
+	vwc, err := clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), ValidatingWebhookConfigurationName, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	case !ownedBy(vwc.Labels, wo):
+		log.Warningf("ValidatingWebhookConfiguration %s is not owned by %s, skip deleting it", ValidatingWebhookConfigurationName, ownerLabels(wo)[LabelInstance])
+		return nil
+	}
+	if err := clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(context.TODO(), ValidatingWebhookConfigurationName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %v", ValidatingWebhookConfigurationName, err)
+	}
+	log.Infof("ValidatingWebhookConfiguration %s has been deleted", ValidatingWebhookConfigurationName)
+	return nil
+}
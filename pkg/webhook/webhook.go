@@ -5,21 +5,35 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/audit"
 	"github.com/CloudNativeGame/fake-time-injector/pkg/k8s"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/webhook/util/generator"
 	"github.com/CloudNativeGame/fake-time-injector/plugins"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
 	"io/ioutil"
 	addmissionV1 "k8s.io/api/admission/v1"
+	addmissionV1beta1 "k8s.io/api/admission/v1beta1"
 	mutateV1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	log "k8s.io/klog"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -34,10 +48,13 @@ var (
 var (
 	MutatingWebhookConfigurationName = "kubernetes-faketime-injector"
 	MutatingWebhookConfigurationPath = "/mutate"
+	MetricsPath                      = "/metrics"
 )
 
 func init() {
 	_ = mutateV1.AddToScheme(runtimeScheme)
+	_ = addmissionV1.AddToScheme(runtimeScheme)
+	_ = addmissionV1beta1.AddToScheme(runtimeScheme)
 	// defaulting with webhooks:
 	// https://github.com/kubernetes/kubernetes/issues/57982
 	_ = v1.AddToScheme(runtimeScheme)
@@ -47,12 +64,44 @@ func init() {
 type WebHookServer struct {
 	pluginManager *plugins.PluginManager
 	clientSet     kubernetes.Interface
-	Options       *WebHookOptions
-	Server        *http.Server
+	dynamicClient dynamic.Interface
+	broadcaster   record.EventBroadcaster
+	recorder      record.EventRecorder
+	// the events of the pods without owner, recorded once the pods are created
+	podEvents workqueue.DelayingInterface
+	Options   *WebHookOptions
+	Server    *http.Server
+	// plain http server exposing the metrics
+	MetricsServer *http.Server
+	// plain http server exposing the health checks
+	HealthServer *http.Server
+	// nil if the audit log is disabled
+	auditLogger *audit.Logger
+	// set to 1 while the serve loop is running
+	serving int32
+	// set to 1 once the shutdown starts, readiness fails while draining
+	draining int32
+	// set to 1 while this replica holds the leader election lease
+	leading int32
+	// the cached result of the registration check of the readiness probe
+	registrationMu        sync.Mutex
+	registrationCheckedAt time.Time
+	registrationErr       error
+	// the current serving certs, swapped by the cert rotator
+	certMu         sync.RWMutex
+	certSyncMu     sync.Mutex
+	certs          *generator.Artifacts
+	tlsPair        *tls.Certificate
+	previousCACert []byte
+	// closed to stop the background goroutines
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	background sync.WaitGroup
 }
 
 // Http handler of patch request
 func (ws *WebHookServer) Serve(w http.ResponseWriter, r *http.Request) {
+	webhook := webhookOfPath(r.URL.Path)
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -61,6 +110,7 @@ func (ws *WebHookServer) Serve(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(body) == 0 {
 		log.Error("Empty body of patch body.")
+		ws.auditDecision(webhook, nil, badRequestResponse(metav1.StatusReasonBadRequest, "empty body"))
 		http.Error(w, "empty body", http.StatusBadRequest)
 		return
 	}
@@ -69,45 +119,46 @@ func (ws *WebHookServer) Serve(w http.ResponseWriter, r *http.Request) {
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
 		log.Errorf("Content-Type=%s, expect application/json", contentType)
+		ws.auditDecision(webhook, nil, badRequestResponse(metav1.StatusReasonUnsupportedMediaType, "invalid Content-Type "+contentType))
 		http.Error(w, "invalid Content-Type, expect `application/json`", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	// decode response
+	// decode response, v1beta1 reviews are answered in v1beta1
 	var admissionResponse *addmissionV1.AdmissionResponse
-	ar := addmissionV1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+	var req *addmissionV1.AdmissionRequest
+	ar, apiVersion, err := decodeAdmissionReview(body)
+	if err != nil {
 		log.Errorf("Can't decode body: %v", err)
-		admissionResponse = &addmissionV1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+		metrics.AdmissionRequests.WithLabelValues("UNKNOWN", metrics.OutcomeError).Inc()
+		admissionResponse = badRequestResponse(metav1.StatusReasonBadRequest, err.Error())
+	} else if ar.Request == nil {
+		log.Error("AdmissionReview without request")
+		metrics.AdmissionRequests.WithLabelValues("UNKNOWN", metrics.OutcomeError).Inc()
+		admissionResponse = badRequestResponse(metav1.StatusReasonBadRequest, "admission review without request")
 	} else {
-		// handle path and return mutate response
-		if r.URL.Path == "/mutate" {
-			admissionResponse = ws.mutate(&ar)
+		req = ar.Request
+		// handle path and return mutate or validate response
+		switch r.URL.Path {
+		case MutatingWebhookConfigurationPath:
+			admissionResponse = ws.mutate(ar)
+		case ValidatingWebhookConfigurationPath:
+			admissionResponse = ws.validate(ar)
+		case AuthorizationPath:
+			admissionResponse = ws.authorize(ar)
 		}
 	}
+	ws.auditDecision(webhook, req, admissionResponse)
 
 	// wrapper admissionReview response
-	admissionReview := addmissionV1.AdmissionReview{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "AdmissionReview",
-			APIVersion: "admission.k8s.io/v1",
-		},
-	}
-	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-		if ar.Request != nil {
-			admissionReview.Response.UID = ar.Request.UID
-		}
+	if admissionResponse != nil && ar != nil && ar.Request != nil {
+		admissionResponse.UID = ar.Request.UID
 	}
-
-	resp, err := json.Marshal(admissionReview)
+	resp, err := encodeAdmissionReview(apiVersion, admissionResponse)
 	if err != nil {
 		log.Errorf("Can't encode response: %v", err)
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	if _, err := w.Write(resp); err != nil {
@@ -122,36 +173,135 @@ func (ws *WebHookServer) mutate(ar *addmissionV1.AdmissionReview) *addmissionV1.
 	// default log level is 2
 	log.V(5).Infof("AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
 		req.Kind, req.Namespace, req.Name, req.Object, req.UID, req.Operation, req.UserInfo)
+	if ws.Options.MutateWorkloads {
+		if w, ok := matchWorkload(req.Resource); ok {
+			return ws.mutateWorkload(req, w)
+		}
+	}
 	raw := req.Object.Raw
 	pod := &v1.Pod{}
-	if req.Operation == addmissionV1.Create {
+	oldPod := &v1.Pod{}
+	if req.Operation == addmissionV1.Create || req.Operation == addmissionV1.Update {
 		if err := json.Unmarshal(raw, pod); err != nil {
 			log.Errorf("Failed to unmarshal pod %v,because of %v", raw, err)
-			return &addmissionV1.AdmissionResponse{
-				Allowed: true,
-			}
+			metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+			return errorResponse(fmt.Sprintf("failed to decode the pod: %v", err))
 		}
+		// the namespace is not set in the object of pods created by controllers
+		if pod.Namespace == "" {
+			pod.Namespace = req.Namespace
+		}
+		if allowed, reason := ws.authorizeInjection(req, pod); !allowed {
+			return ws.unauthorizedResponse(req, reason)
+		}
+	}
+	if req.Operation == addmissionV1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, oldPod); err != nil {
+			log.Errorf("Failed to unmarshal old pod %v,because of %v", req.OldObject.Raw, err)
+			metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+			return errorResponse(fmt.Sprintf("failed to decode the old pod: %v", err))
+		}
+	}
+	start := time.Now()
+	var patchBytes []byte
+	var results []utils.PatchResult
+	var err error
+	if req.Operation == addmissionV1.Update {
+		patchBytes, results, err = ws.pluginManager.HandleUpdatePod(oldPod, pod)
+	} else {
+		patchBytes, results, err = ws.pluginManager.HandlePatchPod(pod, req.Operation)
 	}
-	patchBytes, err := ws.pluginManager.HandlePatchPod(pod, req.Operation)
+	metrics.PatchDuration.Observe(time.Since(start).Seconds())
+	ws.recordEvents(pod, results)
 	if err != nil {
 		log.Errorf("Failed to patch pod %v,because of %v", pod, err)
-		return &addmissionV1.AdmissionResponse{
-			Allowed: true,
-		}
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+		return errorResponse(fmt.Sprintf("failed to patch the pod: %v", err))
 	}
+	return patchResponse(req, patchBytes, results)
+}
+
+// patchResponse allows the request with the json patch if there is any, the failed plugin results are returned as warnings to the client
+func patchResponse(req *addmissionV1.AdmissionRequest, patchBytes []byte, results []utils.PatchResult) *addmissionV1.AdmissionResponse {
+	warnings := admissionWarnings(results)
 	if patchBytes != nil {
-		response := &addmissionV1.AdmissionResponse{Allowed: true}
+		response := &addmissionV1.AdmissionResponse{Allowed: true, Warnings: warnings}
 		response.Patch = patchBytes
 		patchType := addmissionV1.PatchTypeJSONPatch
 		response.PatchType = &patchType
 		// change patch debug log level to 5
-		log.V(5).Infof("Successfully patch pod %s in %s with pathOps %v", pod.Name, pod.Namespace, string(patchBytes))
+		log.V(5).Infof("Successfully patch %s %s in %s with pathOps %v", req.Kind.Kind, req.Name, req.Namespace, string(patchBytes))
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomePatched).Inc()
 		return response
 	}
 
+	metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+	return &addmissionV1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+	}
+}
+
+// errorResponse admits the request unchanged if it could not be handled, the error is recorded in the audit log
+func errorResponse(message string) *addmissionV1.AdmissionResponse {
 	return &addmissionV1.AdmissionResponse{
 		Allowed: true,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusInternalServerError,
+			Reason:  metav1.StatusReasonInternalError,
+			Message: message,
+		},
+	}
+}
+
+// badRequestResponse answers a request which is not a valid admission review
+func badRequestResponse(reason metav1.StatusReason, message string) *addmissionV1.AdmissionResponse {
+	code := int32(http.StatusBadRequest)
+	if reason == metav1.StatusReasonUnsupportedMediaType {
+		code = http.StatusUnsupportedMediaType
+	}
+	return &addmissionV1.AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
+
+// webhookOfPath names the webhook served at the path in the audit log
+func webhookOfPath(path string) string {
+	switch path {
+	case MutatingWebhookConfigurationPath:
+		return "mutate"
+	case ValidatingWebhookConfigurationPath:
+		return "validate"
+	case AuthorizationPath:
+		return "authorize"
+	}
+	return path
+}
+
+// admissionWarnings returns the messages of the plugins which could not patch the object
+func admissionWarnings(results []utils.PatchResult) []string {
+	var warnings []string
+	for _, result := range results {
+		if isWarning(result.Reason) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", result.Plugin, result.Message))
+		}
 	}
+	return warnings
+}
+
+// registerWebhookConfigurations registers the MutatingWebhookConfiguration and the ValidatingWebhookConfiguration
+func (ws *WebHookServer) registerWebhookConfigurations() error {
+	defer ws.resetRegistrationCheck()
+	if err := ws.registerMutatingWebhookConfiguration(); err != nil {
+		return err
+	}
+	return ws.registerValidatingWebhookConfiguration()
 }
 
 // register MutatingWebHookConfiguration
@@ -172,6 +322,20 @@ func (ws *WebHookServer) registerMutatingWebhookConfiguration() error {
 		return err
 	}
 
+	rules := []mutateV1.RuleWithOperations{
+		{
+			Operations: []mutateV1.OperationType{mutateV1.Create, mutateV1.Update, mutateV1.Delete},
+			Rule: mutateV1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		},
+	}
+	if ws.Options.MutateWorkloads {
+		rules = append(rules, workloadRules()...)
+	}
+
 	sideEffectClassNone := mutateV1.SideEffectClassNone
 	ignore := mutateV1.Ignore
 	webhook := []mutateV1.MutatingWebhook{
@@ -187,45 +351,37 @@ func (ws *WebHookServer) registerMutatingWebhookConfiguration() error {
 					Port:      &portInt32,
 					Path:      &MutatingWebhookConfigurationPath,
 				},
-				CABundle: ws.Options.CaCert.CACert,
-			},
-			Rules: []mutateV1.RuleWithOperations{
-				{
-					Operations: []mutateV1.OperationType{mutateV1.Create, mutateV1.Update, mutateV1.Delete},
-					Rule: mutateV1.Rule{
-						APIGroups:   []string{""},
-						APIVersions: []string{"v1"},
-						Resources:   []string{"pods"},
-					},
-				},
+				CABundle: ws.caBundle(),
 			},
+			Rules: rules,
 		},
 	}
 
-	if err := checkMutatingConfiguration(clientSet, webhook); err != nil {
+	if err := checkMutatingConfiguration(clientSet, webhook, ownerLabels(ws.Options)); err != nil {
 		return fmt.Errorf("failed to check mutating webhook,because of %s", err.Error())
 	}
 	log.Infof("MutatingWebhookConfiguration %s has been created", MutatingWebhookConfigurationName)
 	return nil
 }
 
-func checkMutatingConfiguration(kubeClient kubernetes.Interface, m []mutateV1.MutatingWebhook) error {
+func checkMutatingConfiguration(kubeClient kubernetes.Interface, m []mutateV1.MutatingWebhook, labels map[string]string) error {
 	mwc, err := kubeClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), MutatingWebhookConfigurationName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// create new webhook
-			return createMutatingWebhook(kubeClient, m)
+			return createMutatingWebhook(kubeClient, m, labels)
 		} else {
 			return err
 		}
 	}
-	return updateMutatingWebhook(mwc, kubeClient, m)
+	return updateMutatingWebhook(mwc, kubeClient, m, labels)
 }
 
-func createMutatingWebhook(kubeClient kubernetes.Interface, webhook []mutateV1.MutatingWebhook) error {
+func createMutatingWebhook(kubeClient kubernetes.Interface, webhook []mutateV1.MutatingWebhook, labels map[string]string) error {
 	webhookConfig := &mutateV1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: MutatingWebhookConfigurationName,
+			Name:   MutatingWebhookConfigurationName,
+			Labels: labels,
 		},
 		Webhooks: webhook,
 	}
@@ -236,34 +392,169 @@ func createMutatingWebhook(kubeClient kubernetes.Interface, webhook []mutateV1.M
 	return nil
 }
 
-func updateMutatingWebhook(mwc *mutateV1.MutatingWebhookConfiguration, kubeClient kubernetes.Interface, webhook []mutateV1.MutatingWebhook) error {
+func updateMutatingWebhook(mwc *mutateV1.MutatingWebhookConfiguration, kubeClient kubernetes.Interface, webhook []mutateV1.MutatingWebhook, labels map[string]string) error {
 	mwc.Webhooks = webhook
+	if mwc.Labels == nil {
+		mwc.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		mwc.Labels[k] = v
+	}
 	if _, err := kubeClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), mwc, metav1.UpdateOptions{}); err != nil {
 		return fmt.Errorf("failed to update %s: %v", MutatingWebhookConfigurationName, err)
 	}
 	return nil
 }
 
-func (ws *WebHookServer) Run() (err error) {
-	if err = ws.registerMutatingWebhookConfiguration(); err != nil {
-		log.Errorf("Failed to register MutatingWebhookConfiguration,because of %v", err)
+// Run serves the admission requests until the context is canceled, then drains and shuts down the servers
+func (ws *WebHookServer) Run(ctx context.Context) (err error) {
+	leaderCtx, cancelLeader := context.WithCancel(ctx)
+	defer cancelLeader()
+	if err = ws.runLeaderTasks(leaderCtx, func(context.Context) error {
+		// the shared certs are provisioned before the CABundle is registered
+		if ws.Options.sharedCerts() {
+			if _, err := ws.syncCerts(); err != nil {
+				return err
+			}
+		}
+		return ws.registerWebhookConfigurations()
+	}, ws.detectStaleRegistrations, ws.collectAnchors); err != nil {
+		log.Errorf("Failed to register webhook configurations,because of %v", err)
+		return err
+	}
+	ws.background.Add(1)
+	go func() {
+		defer ws.background.Done()
+		ws.rotateCerts(leaderCtx)
+	}()
+	ws.background.Add(1)
+	go func() {
+		defer ws.background.Done()
+		ws.runPodEvents()
+	}()
+	go func() {
+		if err := ws.MetricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Failed to serve metrics,because of %v", err)
+		}
+	}()
+	go func() {
+		if err := ws.HealthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Failed to serve health checks,because of %v", err)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", ws.Server.Addr)
+	if err != nil {
 		return err
 	}
-	return ws.Server.ListenAndServeTLS("", "")
+	atomic.StoreInt32(&ws.serving, 1)
+	defer atomic.StoreInt32(&ws.serving, 0)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- ws.Server.ServeTLS(ln, "", "")
+	}()
+
+	select {
+	case err = <-serveErr:
+		cancelLeader()
+		ws.stopBackground()
+		return err
+	case <-ctx.Done():
+	}
+	return ws.shutdown()
+}
+
+// shutdown fails the readiness first, waits for the drain period and then stops all servers and background goroutines
+func (ws *WebHookServer) shutdown() error {
+	atomic.StoreInt32(&ws.draining, 1)
+	log.Infof("Shutting down, draining for %v", ws.Options.ShutdownDrainPeriod)
+	time.Sleep(ws.Options.ShutdownDrainPeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ws.Options.ShutdownTimeout)
+	defer cancel()
+	err := ws.Server.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("Failed to shutdown webhook server,because of %v", err)
+	}
+	for _, server := range []*http.Server{ws.MetricsServer, ws.HealthServer} {
+		if serr := server.Shutdown(ctx); serr != nil {
+			log.Errorf("Failed to shutdown server %s,because of %v", server.Addr, serr)
+		}
+	}
+	ws.stopBackground()
+	log.Info("Webhook server has been shut down")
+	return err
+}
+
+func (ws *WebHookServer) stopBackground() {
+	ws.stopOnce.Do(func() { close(ws.stopCh) })
+	// the servers have stopped, no more events are queued
+	if ws.podEvents != nil {
+		ws.podEvents.ShutDown()
+	}
+	ws.background.Wait()
+	ws.pluginManager.Stop()
+	if ws.broadcaster != nil {
+		ws.broadcaster.Shutdown()
+	}
+	if err := ws.auditLogger.Close(); err != nil {
+		log.Errorf("Failed to close audit log,because of %v", err)
+	}
 }
 
 // NewWebHookServer return mutate web server
 func NewWebHookServer(wo *WebHookOptions) (ws *WebHookServer, err error) {
 	k8s.InitClientSetOrDie("", wo.KubeConf)
 
+	broadcaster := newEventBroadcaster(k8s.GetClientSet())
 	ws = &WebHookServer{
 		clientSet:     k8s.GetClientSet(),
+		dynamicClient: k8s.GetDynamicClient(),
+		broadcaster:   broadcaster,
+		recorder:      broadcaster.NewRecorder(runtimeScheme, v1.EventSource{Component: EventSourceComponent}),
+		podEvents:     workqueue.NewNamedDelayingQueue("pod-events"),
 		Options:       wo,
 		pluginManager: plugins.NewPluginManager(),
+		stopCh:        make(chan struct{}),
 		Server: &http.Server{
-			Addr:      fmt.Sprintf(":%v", wo.Port),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{wo.TLSPair}},
+			Addr: fmt.Sprintf(":%v", wo.Port),
+		},
+		MetricsServer: &http.Server{
+			Addr: fmt.Sprintf(":%v", wo.MetricsPort),
 		},
+		HealthServer: &http.Server{
+			Addr: fmt.Sprintf(":%v", wo.HealthPort),
+		},
+	}
+	if wo.LeaderElection {
+		// the replicas share the cluster mode anchors, so that the pods admitted by any replica continue from the same fake time
+		faketime.SetAnchorStore(faketime.NewConfigMapAnchorStore(ws.clientSet, wo.ServiceNamespace, AnchorConfigMapName, ownerLabels(wo)))
+	}
+	faketime.SetNativeSidecarSupported(nativeSidecarSupported(ws.clientSet))
+	if wo.AuditLog != "" {
+		if ws.auditLogger, err = audit.NewLogger(wo.AuditLog, wo.AuditLogMaxSize, wo.AuditLogMaxBackups); err != nil {
+			return nil, err
+		}
+	}
+	if wo.CaCert != nil {
+		ws.setCerts(wo.CaCert, wo.TLSPair)
 	}
+	ws.Server.TLSConfig = wo.tlsConfig(ws.getCertificate)
 	return ws, nil
 }
+
+// nativeSidecarSupported checks the version of the api server, older ones silently drop the restart policy of init containers
+func nativeSidecarSupported(clientSet kubernetes.Interface) bool {
+	info, err := clientSet.Discovery().ServerVersion()
+	if err != nil {
+		log.Warningf("Failed to get the version of the api server, native sidecars are disabled,because of %v", err)
+		return false
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		log.Warningf("Failed to parse the version %s of the api server, native sidecars are disabled,because of %v", info.GitVersion, err)
+		return false
+	}
+	return serverVersion.AtLeast(version.MustParseGeneric(faketime.MinNativeSidecarVersion))
+}
@@ -0,0 +1,29 @@
+package webhook
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNativeSidecarSupported(t *testing.T) {
+	tests := []struct {
+		gitVersion string
+		supported  bool
+	}{
+		{gitVersion: "v1.24.2", supported: false},
+		{gitVersion: "v1.28.3", supported: false},
+		{gitVersion: "v1.29.0", supported: true},
+		{gitVersion: "v1.30.1-gke.1000", supported: true},
+		{gitVersion: "", supported: false},
+	}
+	for _, tt := range tests {
+		clientSet := fake.NewSimpleClientset()
+		clientSet.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: tt.gitVersion}
+		if supported := nativeSidecarSupported(clientSet); supported != tt.supported {
+			t.Errorf("%q: expected supported %v, got %v", tt.gitVersion, tt.supported, supported)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	addmissionV1 "k8s.io/api/admission/v1"
+	mutateV1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	log "k8s.io/klog"
+	"strings"
+	"time"
+)
+
+// workload is a resource creating pods from a pod template
+type workload struct {
+	Group    string
+	Version  string
+	Resource string
+	Kind     string
+	// fields of the pod template in the workload
+	TemplatePath []string
+	// the workload is created by the controller of another workload, it is only checked by the authorization
+	AuthorizeOnly bool
+}
+
+// workloads are mutated with --mutate-workloads, so that the injected spec is visible in the workload
+var workloads = []workload{
+	{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment", TemplatePath: []string{"spec", "template"}},
+	{Group: "apps", Version: "v1", Resource: "statefulsets", Kind: "StatefulSet", TemplatePath: []string{"spec", "template"}},
+	{Group: "apps", Version: "v1", Resource: "daemonsets", Kind: "DaemonSet", TemplatePath: []string{"spec", "template"}},
+	{Group: "batch", Version: "v1", Resource: "jobs", Kind: "Job", TemplatePath: []string{"spec", "template"}},
+	{Group: "batch", Version: "v1", Resource: "cronjobs", Kind: "CronJob", TemplatePath: []string{"spec", "jobTemplate", "spec", "template"}},
+	{Group: "game.kruise.io", Version: "v1alpha1", Resource: "gameserversets", Kind: "GameServerSet", TemplatePath: []string{"spec", "gameServerTemplate"}},
+	// mutating their templates would make the templates differ from the ones of their owners, their controllers would keep replacing them
+	{Group: "apps", Version: "v1", Resource: "replicasets", Kind: "ReplicaSet", TemplatePath: []string{"spec", "template"}, AuthorizeOnly: true},
+	{Group: "", Version: "v1", Resource: "replicationcontrollers", Kind: "ReplicationController", TemplatePath: []string{"spec", "template"}, AuthorizeOnly: true},
+	{Group: "apps.kruise.io", Version: "v1alpha1", Resource: "clonesets", Kind: "CloneSet", TemplatePath: []string{"spec", "template"}, AuthorizeOnly: true},
+	{Group: "apps.kruise.io", Version: "v1beta1", Resource: "statefulsets", Kind: "StatefulSet", TemplatePath: []string{"spec", "template"}, AuthorizeOnly: true},
+	{Group: "apps.kruise.io", Version: "v1alpha1", Resource: "daemonsets", Kind: "DaemonSet", TemplatePath: []string{"spec", "template"}, AuthorizeOnly: true},
+}
+
+// workloadRules returns the webhook rules of the mutated workloads
+func workloadRules() []mutateV1.RuleWithOperations {
+	rules := make([]mutateV1.RuleWithOperations, 0, len(workloads))
+	for _, w := range workloads {
+		if !w.AuthorizeOnly {
+			rules = append(rules, w.rule())
+		}
+	}
+	return rules
+}
+
+// authorizationRules returns the webhook rules of all resources carrying a pod template
+func authorizationRules() []mutateV1.RuleWithOperations {
+	rules := make([]mutateV1.RuleWithOperations, 0, len(workloads))
+	for _, w := range workloads {
+		rules = append(rules, w.rule())
+	}
+	return rules
+}
+
+func (w workload) rule() mutateV1.RuleWithOperations {
+	return mutateV1.RuleWithOperations{
+		Operations: []mutateV1.OperationType{mutateV1.Create, mutateV1.Update},
+		Rule: mutateV1.Rule{
+			APIGroups:   []string{w.Group},
+			APIVersions: []string{w.Version},
+			Resources:   []string{w.Resource},
+		},
+	}
+}
+
+// matchWorkload finds the workload of the requested resource
+func matchWorkload(resource metav1.GroupVersionResource) (workload, bool) {
+	for _, w := range workloads {
+		if w.Group == resource.Group && w.Resource == resource.Resource {
+			return w, true
+		}
+	}
+	return workload{}, false
+}
+
+// matchWorkloadKind finds the workload of a manifest
+func matchWorkloadKind(apiVersion string, kind string) (workload, bool) {
+	group := ""
+	if i := strings.Index(apiVersion, "/"); i >= 0 {
+		group = apiVersion[:i]
+	}
+	for _, w := range workloads {
+		if w.Kind == kind && w.Group == group {
+			return w, true
+		}
+	}
+	return workload{}, false
+}
+
+// mutateWorkload patches the pod template of the workload with the same plugins as pods
+func (ws *WebHookServer) mutateWorkload(req *addmissionV1.AdmissionRequest, w workload) *addmissionV1.AdmissionResponse {
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		log.Errorf("Failed to unmarshal %s %s/%s,because of %v", w.Resource, req.Namespace, req.Name, err)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+		return errorResponse(fmt.Sprintf("failed to decode the %s: %v", w.Resource, err))
+	}
+	template, found, err := podTemplateOf(obj, w)
+	if err != nil {
+		log.Errorf("Failed to decode the pod template of %s %s/%s,because of %v", w.Resource, req.Namespace, req.Name, err)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+		return errorResponse(fmt.Sprintf("failed to decode the pod template: %v", err))
+	}
+	if !found {
+		log.V(5).Infof("Skip %s %s/%s without pod template", w.Resource, req.Namespace, req.Name)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeAllowed).Inc()
+		return &addmissionV1.AdmissionResponse{Allowed: true}
+	}
+	if allowed, reason := ws.authorizeInjection(req, &v1.Pod{ObjectMeta: template.ObjectMeta}); !allowed {
+		return ws.unauthorizedResponse(req, reason)
+	}
+
+	start := time.Now()
+	patchBytes, results, err := ws.pluginManager.HandlePatchPodTemplate(template, req.Namespace, w.templatePointer())
+	metrics.PatchDuration.Observe(time.Since(start).Seconds())
+	uid, _, _ := unstructured.NestedString(obj, "metadata", "uid")
+	ref := &v1.ObjectReference{
+		APIVersion: req.Kind.Group + "/" + req.Kind.Version,
+		Kind:       req.Kind.Kind,
+		Name:       req.Name,
+		Namespace:  req.Namespace,
+		UID:        types.UID(uid),
+	}
+	ws.emitEvents(ref, fmt.Sprintf("%s %s", req.Kind.Kind, req.Name), results)
+	if err != nil {
+		log.Errorf("Failed to patch the pod template of %s %s/%s,because of %v", w.Resource, req.Namespace, req.Name, err)
+		metrics.AdmissionRequests.WithLabelValues(string(req.Operation), metrics.OutcomeError).Inc()
+		return errorResponse(fmt.Sprintf("failed to patch the pod template: %v", err))
+	}
+	return patchResponse(req, patchBytes, results)
+}
+
+// podTemplateOf decodes the pod template of the workload object
+func podTemplateOf(obj map[string]interface{}, w workload) (*v1.PodTemplateSpec, bool, error) {
+	templateObj, found, err := unstructured.NestedMap(obj, w.TemplatePath...)
+	if err != nil || !found {
+		return nil, false, nil
+	}
+	raw, err := json.Marshal(templateObj)
+	if err != nil {
+		return nil, false, err
+	}
+	template := &v1.PodTemplateSpec{}
+	if err := json.Unmarshal(raw, template); err != nil {
+		return nil, false, err
+	}
+	return template, true, nil
+}
+
+// templatePointer is the json pointer of the pod template in the workload
+func (w workload) templatePointer() string {
+	return "/" + strings.Join(w.TemplatePath, "/")
+}
@@ -0,0 +1,197 @@
+package faketime
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anchor is the fake time opened by the first pod of an anchor group, the later pods of the group continue from it
+type Anchor struct {
+	Start   time.Time `json:"start"`
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// AnchorStore keeps the cluster mode anchors
+type AnchorStore interface {
+	// LoadOrStore returns the live anchor of the group, or stores the given one if the group has none.
+	// admit checks the returned anchor, a rejected anchor is not stored.
+	LoadOrStore(group string, anchor Anchor, admit func(Anchor) error) (Anchor, error)
+	// Expire removes the anchors expired before now and returns the number of the live ones
+	Expire(now time.Time) (int, error)
+}
+
+var anchorStore AnchorStore = &memoryAnchorStore{anchors: make(map[string]Anchor)}
+
+// SetAnchorStore replaces the in-memory anchors of this replica, e.g. by the anchors shared by all replicas.
+// It must be called before the webhook serves.
+func SetAnchorStore(store AnchorStore) {
+	anchorStore = store
+}
+
+// ExpireAnchors removes the expired anchors, it is called periodically
+func ExpireAnchors() error {
+	live, err := anchorStore.Expire(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	metrics.ClusterModeAnchors.Set(float64(live))
+	return nil
+}
+
+// memoryAnchorStore keeps the anchors of this replica
+type memoryAnchorStore struct {
+	mu      sync.Mutex
+	anchors map[string]Anchor
+}
+
+func (s *memoryAnchorStore) LoadOrStore(group string, anchor Anchor, admit func(Anchor) error) (Anchor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.anchors[group]; ok && time.Now().Before(existing.Expires) {
+		return existing, admit(existing)
+	}
+	if err := admit(anchor); err != nil {
+		return Anchor{}, err
+	}
+	s.anchors[group] = anchor
+	metrics.ClusterModeAnchors.Set(float64(len(s.anchors)))
+	return anchor, nil
+}
+
+func (s *memoryAnchorStore) Expire(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for group, anchor := range s.anchors {
+		if !now.Before(anchor.Expires) {
+			delete(s.anchors, group)
+			klog.Infof("Key %s has been cleaned\n", group)
+		}
+	}
+	return len(s.anchors), nil
+}
+
+func (s *memoryAnchorStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.anchors = make(map[string]Anchor)
+}
+
+// configMapAnchorStore shares the anchors of all replicas in a ConfigMap, the writes are serialized by its resource version
+type configMapAnchorStore struct {
+	clientSet kubernetes.Interface
+	namespace string
+	name      string
+	labels    map[string]string
+}
+
+// NewConfigMapAnchorStore keeps the anchors in the ConfigMap namespace/name, which is created with the labels if it does not exist
+func NewConfigMapAnchorStore(clientSet kubernetes.Interface, namespace string, name string, labels map[string]string) AnchorStore {
+	return &configMapAnchorStore{clientSet: clientSet, namespace: namespace, name: name, labels: labels}
+}
+
+func (s *configMapAnchorStore) LoadOrStore(group string, anchor Anchor, admit func(Anchor) error) (Anchor, error) {
+	var result Anchor
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+		exists := err == nil
+		if apierrors.IsNotFound(err) {
+			cm = &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name, Labels: s.labels}}
+		} else if err != nil {
+			return err
+		}
+
+		key := anchorKey(group)
+		if existing, ok := decodeAnchor(cm.Data[key]); ok && time.Now().Before(existing.Expires) {
+			result = existing
+			return admit(existing)
+		}
+		if err := admit(anchor); err != nil {
+			return err
+		}
+		data, err := json.Marshal(anchor)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[key] = string(data)
+		result = anchor
+		return s.save(cm, exists)
+	})
+	if err != nil {
+		return Anchor{}, err
+	}
+	return result, nil
+}
+
+func (s *configMapAnchorStore) Expire(now time.Time) (int, error) {
+	live := 0
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			live = 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		expired := 0
+		for key, raw := range cm.Data {
+			if anchor, ok := decodeAnchor(raw); !ok || !now.Before(anchor.Expires) {
+				delete(cm.Data, key)
+				expired++
+			}
+		}
+		live = len(cm.Data)
+		if expired == 0 {
+			return nil
+		}
+		klog.Infof("%d anchors have been cleaned from ConfigMap %s/%s", expired, s.namespace, s.name)
+		return s.save(cm, true)
+	})
+	return live, err
+}
+
+// save creates or updates the ConfigMap, a concurrent creation is retried as a conflict
+func (s *configMapAnchorStore) save(cm *apiv1.ConfigMap, exists bool) error {
+	if exists {
+		_, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+		return err
+	}
+	_, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, s.name, err)
+	}
+	return err
+}
+
+// anchorKey converts the group, a namespace or namespace/GameServerSet, to a ConfigMap key, '_' is invalid in both names
+func anchorKey(group string) string {
+	return strings.ReplaceAll(group, "/", "_")
+}
+
+func decodeAnchor(raw string) (Anchor, bool) {
+	if raw == "" {
+		return Anchor{}, false
+	}
+	var anchor Anchor
+	if err := json.Unmarshal([]byte(raw), &anchor); err != nil {
+		return Anchor{}, false
+	}
+	return anchor, true
+}
@@ -0,0 +1,89 @@
+package faketime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnchorStores(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	stores := map[string]func() AnchorStore{
+		"memory": func() AnchorStore { return &memoryAnchorStore{anchors: make(map[string]Anchor)} },
+		"configmap": func() AnchorStore {
+			return NewConfigMapAnchorStore(clientSet, "kube-system", "anchors", map[string]string{"app": "injector"})
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			allow := func(Anchor) error { return nil }
+			now := time.Now().UTC().Truncate(time.Second)
+			first := Anchor{Start: now, Value: "+1h", Expires: now.Add(time.Minute)}
+
+			// a rejected anchor is not stored
+			errRejected := errors.New("rejected")
+			if _, err := store.LoadOrStore("ns_"+name, first, func(Anchor) error { return errRejected }); !errors.Is(err, errRejected) {
+				t.Fatalf("expected the rejection, got %v", err)
+			}
+			got, err := store.LoadOrStore("ns_"+name, first, allow)
+			if err != nil || got.Value != "+1h" {
+				t.Fatalf("expected the first anchor to be stored, got %+v %v", got, err)
+			}
+			got, err = store.LoadOrStore("ns_"+name, Anchor{Start: now, Value: "+2h", Expires: now.Add(time.Minute)}, allow)
+			if err != nil || got.Value != "+1h" || !got.Start.Equal(now) {
+				t.Errorf("expected the live anchor, got %+v %v", got, err)
+			}
+			if _, err := store.LoadOrStore("ns_"+name+"/gss", Anchor{Start: now, Value: "+3h", Expires: now.Add(time.Hour)}, allow); err != nil {
+				t.Fatal(err)
+			}
+
+			live, err := store.Expire(now.Add(2 * time.Minute))
+			if err != nil || live != 1 {
+				t.Errorf("expected 1 live anchor, got %d %v", live, err)
+			}
+			got, err = store.LoadOrStore("ns_"+name, Anchor{Start: now, Value: "+2h", Expires: now.Add(time.Minute)}, allow)
+			if err != nil || got.Value != "+2h" {
+				t.Errorf("expected a new anchor after the expiry, got %+v %v", got, err)
+			}
+		})
+	}
+
+	cm, err := clientSet.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "anchors", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Labels["app"] != "injector" {
+		t.Errorf("expected the labels of the install, got %v", cm.Labels)
+	}
+	if _, ok := cm.Data["ns_configmap_gss"]; !ok {
+		t.Errorf("expected the GameServerSet group to be a valid key, got %v", cm.Data)
+	}
+}
+
+func TestConfigMapAnchorIsSharedByReplicas(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	leader := NewConfigMapAnchorStore(clientSet, "kube-system", "anchors", nil)
+	follower := NewConfigMapAnchorStore(clientSet, "kube-system", "anchors", nil)
+	allow := func(Anchor) error { return nil }
+	now := time.Now().UTC()
+
+	if _, err := follower.LoadOrStore("default", Anchor{Start: now, Value: "+1d", Expires: now.Add(time.Minute)}, allow); err != nil {
+		t.Fatal(err)
+	}
+	got, err := leader.LoadOrStore("default", Anchor{Start: now.Add(time.Second), Value: "+2d", Expires: now.Add(time.Minute)}, allow)
+	if err != nil || got.Value != "+1d" {
+		t.Errorf("expected the anchor opened on the follower, got %+v %v", got, err)
+	}
+	if live, err := leader.Expire(now.Add(time.Hour)); err != nil || live != 0 {
+		t.Errorf("expected the leader to remove the anchor, got %d %v", live, err)
+	}
+	got, err = follower.LoadOrStore("default", Anchor{Start: now, Value: "+3d", Expires: now.Add(time.Hour)}, allow)
+	if err != nil || got.Value != "+3d" {
+		t.Errorf("expected a new anchor after the leader removed the expired one, got %+v %v", got, err)
+	}
+}
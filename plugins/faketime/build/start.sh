@@ -10,35 +10,207 @@ get_child_pids() {
     done
 }
 
-declare -a child_pids=()
-process_array=(`echo $modify_process_name | tr ',' ' '`)
+# match_pid checks a process against all configured selectors:
+# modify_process_name, modify_process_cmdline and modify_container_name
+match_pid() {
+    local pid=$1
 
-for process_name in ${process_array[@]}
-do
-  sp_pid=`pgrep -x $process_name`
-  if [ -n "$sp_pid" ]
+    # skip the processes of the sidecar itself
+    if [ "$(readlink /proc/$pid/ns/mnt 2>/dev/null)" == "$self_mnt_ns" ]
+    then
+      return 1
+    fi
+
+    if [ ${#process_array[@]} -gt 0 ]
+    then
+      local comm=$(cat /proc/$pid/comm 2>/dev/null)
+      local matched=false
+      for process_name in ${process_array[@]}
+      do
+        if [ "$comm" == "$process_name" ]
+        then
+          matched=true
+          break
+        fi
+      done
+      if [ "$matched" != "true" ]
+      then
+        return 1
+      fi
+    fi
+
+    if [ -n "$modify_process_cmdline" ]
+    then
+      local cmdline=$(tr '\0' ' ' < /proc/$pid/cmdline 2>/dev/null)
+      if ! [[ "$cmdline" =~ $modify_process_cmdline ]]
+      then
+        return 1
+      fi
+    fi
+
+    if [ -n "$modify_container_name" ]
+    then
+      if ! tr '\0' '\n' < /proc/$pid/environ 2>/dev/null | grep -qx "FAKE_TIME_CONTAINER=$modify_container_name"
+      then
+        return 1
+      fi
+    fi
+    return 0
+}
+
+# read_pidfile reads the pid file from the root of the target processes, it is never in the sidecar's own filesystem
+read_pidfile() {
+    for pid in $(ls /proc | grep -E '^[0-9]+$')
+    do
+      if match_pid "$pid" && [ -f "/proc/$pid/root$modify_process_pidfile" ]
+      then
+        cat "/proc/$pid/root$modify_process_pidfile"
+        return
+      fi
+    done
+}
+
+# scan_target_pids fills child_pids with the processes matching the selectors
+scan_target_pids() {
+  child_pids=()
+  if [ -n "$modify_process_pidfile" ]
   then
+    candidate_pids=$(read_pidfile | tr -d '[:space:]')
+  else
+    candidate_pids=$(ls /proc | grep -E '^[0-9]+$')
+  fi
+
+  for sp_pid in $candidate_pids
+  do
+    if [ -z "$modify_process_pidfile" ] && ! match_pid "$sp_pid"
+    then
+      continue
+    fi
     child_pids+=("$sp_pid")
     if [ "$Modify_Sub_Process" == "true" ]
     then
       get_child_pids "$sp_pid"
     fi
+  done
+}
+
+# write_status records the state of every known process, it is checked by the readiness probe of the sidecar
+write_status() {
+  if [ -z "$status_file" ]
+  then
+    return
   fi
-done
+  : > "$status_file.tmp"
+  for pid in ${!modified_pids[@]}
+  do
+    echo "$pid modified" >> "$status_file.tmp"
+  done
+  for pid in ${!failed_attempts[@]}
+  do
+    if [ "${failed_attempts[$pid]}" -gt "$max_retries" ]
+    then
+      echo "$pid failed" >> "$status_file.tmp"
+    fi
+  done
+  mv "$status_file.tmp" "$status_file"
+}
 
+# forget_exited_pids drops the processes that no longer exist, so a restarted process is modified again
+forget_exited_pids() {
+  for pid in ${!modified_pids[@]}
+  do
+    if [ ! -d "/proc/$pid" ]
+    then
+      unset modified_pids[$pid]
+    fi
+  done
+  for pid in ${!failed_attempts[@]}
+  do
+    if [ ! -d "/proc/$pid" ]
+    then
+      unset failed_attempts[$pid]
+    fi
+  done
+}
 
-echo "List of processes that will be modified： ${child_pids[*]}"
-for modify_process_pid in ${child_pids[@]}
-do
-  echo "start modify process pid: ${modify_process_pid}"
-  command="./bin/watchmaker -pid $modify_process_pid -clk_ids CLOCK_REALTIME,CLOCK_MONOTONIC"
-  if [ -n "$delay_second" ]; then
-  command+=" -sec_delta $delay_second"
+# read_spec loads the delay from the spec file, which follows the fake-time-spec annotation of the pod.
+# When the delay changes every process is modified again, watchmaker replaces the delay of an injected process
+read_spec() {
+  if [ -z "$spec_file" ] || [ ! -s "$spec_file" ]
+  then
+    return
   fi
-
-  if [ -n "$delay_nanosecond" ]; then
-  command+=" -nsec_delta $delay_nanosecond"
+  local spec=$(cat "$spec_file")
+  if [ "$spec" == "$applied_spec" ]
+  then
+    return
+  fi
+  if ! [[ "$spec" =~ ^[0-9]+(\.[0-9]+)?$ ]]
+  then
+    echo "ignore invalid spec: $spec"
+    return
+  fi
+  delay_second=${spec%%.*}
+  delay_nanosecond=0
+  if [[ "$spec" == *.* ]]
+  then
+    delay_nanosecond=$((10#${spec#*.}))
+  fi
+  if [ -n "$applied_spec" ]
+  then
+    echo "fake time changed, delay_second: $delay_second delay_nanosecond: $delay_nanosecond"
+    modified_pids=()
+    failed_attempts=()
   fi
+  applied_spec=$spec
+}
 
-  eval $command
-done
\ No newline at end of file
+declare -a child_pids=()
+declare -A modified_pids=()
+declare -A failed_attempts=()
+process_array=(`echo $modify_process_name | tr ',' ' '`)
+self_mnt_ns=$(readlink /proc/self/ns/mnt)
+rescan_interval=${rescan_interval:-0}
+max_retries=${max_retries:-0}
+applied_spec=""
+
+while true
+do
+  forget_exited_pids
+  read_spec
+  scan_target_pids
+
+  echo "List of processes that will be modified： ${child_pids[*]}"
+  for modify_process_pid in ${child_pids[@]}
+  do
+    # never apply the same delay twice to the same process
+    if [ -n "${modified_pids[$modify_process_pid]}" ] || [ "${failed_attempts[$modify_process_pid]:-0}" -gt "$max_retries" ]
+    then
+      continue
+    fi
+    echo "start modify process pid: ${modify_process_pid}"
+    command="./bin/watchmaker -pid $modify_process_pid -clk_ids CLOCK_REALTIME,CLOCK_MONOTONIC"
+    if [ -n "$delay_second" ]; then
+    command+=" -sec_delta $delay_second"
+    fi
+
+    if [ -n "$delay_nanosecond" ]; then
+    command+=" -nsec_delta $delay_nanosecond"
+    fi
+
+    if eval $command
+    then
+      modified_pids[$modify_process_pid]=1
+    else
+      failed_attempts[$modify_process_pid]=$(( ${failed_attempts[$modify_process_pid]:-0} + 1 ))
+      echo "failed to modify process pid: ${modify_process_pid}, attempts: ${failed_attempts[$modify_process_pid]}"
+    fi
+  done
+  write_status
+
+  if [ "$rescan_interval" -le 0 ]
+  then
+    break
+  fi
+  sleep "$rescan_interval"
+done
@@ -3,15 +3,16 @@ package faketime
 import (
 	"errors"
 	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
 	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
 	addmissionV1 "k8s.io/api/admission/v1"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
+	"math"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -28,19 +29,32 @@ const (
 	CLUSTER_MODE_ENV      = "CLUSTER_MODE"
 	NamespaceDelayTimeout = "Namespace_Delay_Timeout"
 	ModifySubProcess      = "Modify_Sub_Process"
+	NativeSidecar         = "cloudnativegame.io/native-sidecar"
+	NATIVE_SIDECAR_ENV    = "NATIVE_SIDECAR"
+	ProcessContainer      = "cloudnativegame.io/process-container"
+	ProcessCmdline        = "cloudnativegame.io/process-cmdline"
+	ProcessPidFile        = "cloudnativegame.io/process-pidfile"
+	ContainerMarkerEnv    = "FAKE_TIME_CONTAINER"
+	RescanInterval        = "cloudnativegame.io/rescan-interval"
+	MaxRetries            = "cloudnativegame.io/max-retries"
+	ReadyAfterModified    = "cloudnativegame.io/ready-after-modified"
+	RESCAN_INTERVAL_ENV   = "WATCHMAKER_RESCAN_INTERVAL"
+	MAX_RETRIES_ENV       = "WATCHMAKER_MAX_RETRIES"
+	WatchMakerStatusFile  = "/tmp/fake-time-status"
+	FakeTimeInjected      = "cloudnativegame.io/fake-time-injected"
+	InjectedMode          = "cloudnativegame.io/fake-time-injected-mode"
+	EffectiveOffset       = "cloudnativegame.io/fake-time-effective-offset"
+	AnchorGroup           = "cloudnativegame.io/fake-time-anchor-group"
+	ModeWatchMaker        = "watchmaker"
+	ModeLibFakeTime       = "libfaketime"
+	FakeTimeSpec          = "cloudnativegame.io/fake-time-spec"
+	SpecVolumeName        = "fake-time-spec"
+	SpecMountPath         = "/etc/fake-time"
+	SpecFile              = "/etc/fake-time/spec"
+	InPlaceUpdate         = "cloudnativegame.io/in-place-update"
+	IN_PLACE_UPDATE_ENV   = "IN_PLACE_UPDATE"
 )
 
-var (
-	delaySecondGroup = make(map[string]namespaceDelayEntry)
-	mu               sync.Mutex
-)
-
-type namespaceDelayEntry struct {
-	startTime time.Time
-	Value     string
-	Timeout   *time.Timer
-}
-
 type FaketimePlugin struct {
 }
 
@@ -55,105 +69,147 @@ func (s *FaketimePlugin) MatchAnnotations(podAnnots map[string]string) bool {
 	return false
 }
 
-func (s *FaketimePlugin) Patch(pod *apiv1.Pod, operation addmissionV1.Operation) []utils.PatchOperation {
+func (s *FaketimePlugin) Patch(pod *apiv1.Pod, operation addmissionV1.Operation) ([]utils.PatchOperation, error) {
 	fakeTime := pod.Annotations[FakeTime]
+	var anchorGroup string
+	var policyErr error
 	val, ok := os.LookupEnv(CLUSTER_MODE_ENV)
-	if ok && val == "true" {
-		if entry, exists := delaySecondGroup[pod.Namespace]; exists {
-			// If the key already exists, the same namespace fake time is used directly
-			klog.Infof("Key %q already exists, start time is %v,using value: %s\n", pod.Namespace, entry.startTime, entry.Value)
-			start := entry.startTime
-			duration := time.Since(start)
-			fakeTime = entry.Value
-			var err error
-			if strings.Contains(fakeTime, ":") {
-				fakeTime, err = timeStrAddDuration(fakeTime, duration)
-
-			} else {
-				fakeTime, err = parseOffsetTime(fakeTime)
+	// pod templates do not start any process, they must not open an anchor window
+	if ok && val == "true" && operation == addmissionV1.Create && !utils.IsPodTemplate(pod) {
+		// pods of a GameServerSet share the anchor of the set, other pods share the anchor of the namespace
+		anchorGroup = anchorGroupOf(pod)
+		var err error
+		// the policy is checked on the fake time of the anchor, which is injected instead of the one of the pod
+		fakeTime, err = resolveAnchor(anchorGroup, fakeTime, func(resolved string) error {
+			policyErr = enforcePolicy(pod, resolved)
+			if errors.Is(policyErr, utils.ErrPolicyViolation) {
+				return policyErr
 			}
-			if err != nil {
-				klog.Errorf("failed to calculate fake time, err: %v", err)
-				return []utils.PatchOperation{}
-			}
-		} else {
-			var namespaceDelayTimeout time.Duration
-			v, _ := os.LookupEnv(NamespaceDelayTimeout)
-			if v != "" {
-				timeout, err := strconv.Atoi(v)
-				if err != nil {
-					klog.Errorf("failed parse time, err: %v", err)
-					return []utils.PatchOperation{}
-				}
-				namespaceDelayTimeout = time.Duration(timeout) * time.Second
-			} else {
-				namespaceDelayTimeout = 40 * time.Second
-			}
-			now := time.Now().UTC()
-			keyEntry := namespaceDelayEntry{
-				startTime: now,
-				Value:     fakeTime,
-				Timeout:   time.AfterFunc(namespaceDelayTimeout, func() { removeNamespaceDelayKey(pod.Namespace) }),
-			}
-			delaySecondGroup[pod.Namespace] = keyEntry
-			klog.Infof("set Key: %v, will be deleted after  %v seconds", pod.Namespace, namespaceDelayTimeout.Seconds())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if operation == addmissionV1.Create {
+		policyErr = enforcePolicy(pod, fakeTime)
+		if errors.Is(policyErr, utils.ErrPolicyViolation) {
+			return nil, policyErr
 		}
 	}
 
 	var opPatches []utils.PatchOperation
 	switch operation {
 	case addmissionV1.Create:
-		for _, container := range pod.Spec.Containers {
-			if container.Name == ContainerName {
-				break
-			}
+		// annotations set to ‘cloudnativegame.io/process-name’ or any other process selector creates a watchmaker that modifies the process time, if not it uses the libfaketime library to modify the time
+		mode := ModeLibFakeTime
+		var err error
+		if isWatchMakerMode(pod.Annotations) {
+			mode = ModeWatchMaker
+			opPatches, err = watchMakerPatches(pod, fakeTime, opPatches)
+		} else {
+			opPatches, err = libFakeTimePatches(pod, fakeTime, opPatches)
+		}
+		if err != nil {
+			return nil, err
 		}
+		if len(opPatches) > 0 {
+			opPatches = append(opPatches, injectionResultPatches(pod, mode, fakeTime, anchorGroup)...)
+		}
+	}
+	if policyErr != nil && len(opPatches) > 0 {
+		return opPatches, policyErr
+	}
+	return opPatches, nil
+}
 
-		// annotations set to ‘cloudnativegame.io/process-name’ creates a watchmaker that modifies the process time, if not it uses the libfaketime library to modify the time
-		_, ok = pod.Annotations[ModifyProcessName]
-		if ok {
-			opPatches = watchMakerPatches(pod, fakeTime, opPatches)
+// injectionResultPatches records the resolved mode, offset and anchor group on the pod,
+// and the spec read by the running pod from the downward API volume
+func injectionResultPatches(pod *apiv1.Pod, mode string, fakeTime string, anchorGroup string) []utils.PatchOperation {
+	annotations := map[string]string{
+		FakeTimeInjected: "true",
+		InjectedMode:     mode,
+	}
+	if utils.IsPodTemplate(pod) {
+		// the offset is resolved for every pod, writing it into the template would roll out the workload on every update
+	} else {
+		if offset, err := effectiveOffset(fakeTime); err == nil {
+			annotations[EffectiveOffset] = offset
 		} else {
-			opPatches = libFakeTimePatches(pod, fakeTime, opPatches)
+			klog.Warningf("failed to resolve the effective offset of pod %s/%s, err: %v", pod.Namespace, pod.Name, err)
 		}
+		if spec, err := resolveSpec(mode, fakeTime, true); err == nil {
+			annotations[FakeTimeSpec] = spec
+		} else {
+			klog.Warningf("failed to resolve the fake time spec of pod %s/%s, err: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	if anchorGroup != "" {
+		annotations[AnchorGroup] = anchorGroup
+	}
+	return annotationPatches(pod, annotations)
+}
+
+// annotationPatches sets the annotations which differ from the pod, in a stable order
+func annotationPatches(pod *apiv1.Pod, annotations map[string]string) []utils.PatchOperation {
+	var opPatches []utils.PatchOperation
+	for _, key := range []string{FakeTimeInjected, InjectedMode, EffectiveOffset, AnchorGroup, FakeTimeSpec} {
+		value, ok := annotations[key]
+		if !ok || pod.Annotations[key] == value {
+			continue
+		}
+		opPatches = append(opPatches, utils.PatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + utils.EscapeJSONPointer(key),
+			Value: value,
+		})
 	}
 	return opPatches
 }
 
-func libFakeTimePatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchOperation) []utils.PatchOperation {
-	err := validateLibFakeTime(fakeTime)
+// effectiveOffset converts an absolute fake time or a relative offset to the offset in seconds from now, e.g. '+86400s'
+func effectiveOffset(fakeTime string) (string, error) {
+	var seconds float64
+	if strings.Contains(fakeTime, ":") {
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", fakeTime)
+		if err != nil {
+			return "", err
+		}
+		seconds = time.Until(t).Round(time.Second).Seconds()
+	} else {
+		offset, err := parseOffsetTime(fakeTime)
+		if err != nil {
+			return "", err
+		}
+		seconds, err = strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return "", err
+		}
+	}
+	offset := strconv.FormatFloat(seconds, 'f', -1, 64) + "s"
+	if seconds >= 0 {
+		offset = "+" + offset
+	}
+	return offset, nil
+}
+
+func libFakeTimePatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchOperation) ([]utils.PatchOperation, error) {
+	fakeTimeEnv, err := resolveSpec(ModeLibFakeTime, fakeTime, true)
 	if err != nil {
-		klog.Errorf("invalid faketime in libfaketime mode: %v", err)
-		return opPatches
+		return nil, fmt.Errorf("invalid faketime in libfaketime mode: %v", err)
 	}
-	// add volume
-	var patchVolume bool
-	volumePath := "/spec/volumes"
-	var valueVolume interface{}
+	// add volumes, the spec volume lets the running pod pick up a new fake time
+	inPlace := useInPlaceUpdate(pod)
 	vol := apiv1.Volume{
 		Name: "faketime",
 		VolumeSource: apiv1.VolumeSource{
 			EmptyDir: &apiv1.EmptyDirVolumeSource{},
 		},
 	}
-	if len(pod.Spec.Volumes) == 0 {
-		valueVolume = []apiv1.Volume{vol}
-		patchVolume = true
-	} else {
-		if !hasVolume(pod, vol.Name) {
-			volumePath += "/-"
-			valueVolume = vol
-			patchVolume = true
-		}
-	}
-	if patchVolume {
-		addVolumePatch := utils.PatchOperation{
-			Op:    "add",
-			Path:  volumePath,
-			Value: valueVolume,
-		}
-		opPatches = append(opPatches, addVolumePatch)
+	vols := []apiv1.Volume{vol}
+	if inPlace {
+		vols = append(vols, specVolume())
 	}
+	opPatches = append(opPatches, volumePatches(pod, vols...)...)
 
 	// add init container
 	var patchInitContainer bool
@@ -194,63 +250,35 @@ func libFakeTimePatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.Patch
 	}
 
 	// add volumemount
-	var patchVolumeMount bool
-	var valueVolumeMount interface{}
-	var volumeMountPath string
 	vm := apiv1.VolumeMount{
 		Name:      "faketime",
 		MountPath: LibFakeTimeMountPath,
 	}
-	for num, container := range pod.Spec.Containers {
-		if len(container.VolumeMounts) == 0 {
-			valueVolumeMount = []apiv1.VolumeMount{vm}
-			volumeMountPath = fmt.Sprintf("/spec/containers/%d/volumeMounts", num)
-			patchVolumeMount = true
-		} else {
-			if !hasVolumeMount(container, vm.Name) {
-				valueVolumeMount = vm
-				volumeMountPath = fmt.Sprintf("/spec/containers/%d/volumeMounts/-", num)
-				patchVolumeMount = true
-			}
-		}
-		if patchVolumeMount {
-			addConVolumeMountPatch := utils.PatchOperation{
-				Op:    "add",
-				Path:  volumeMountPath,
-				Value: valueVolumeMount,
-			}
-			opPatches = append(opPatches, addConVolumeMountPatch)
-		}
+	mounts := []apiv1.VolumeMount{vm}
+	if inPlace {
+		mounts = append(mounts, specVolumeMount())
 	}
-
-	//add container env
-	var patchContainerEnv bool
-	var valueContainerEnv interface{}
-	var ContainerEnvPath string
-	var fakeTimeEnv string
-
-	if strings.Contains(fakeTime, ":") {
-		fakeTimeEnv = fmt.Sprintf("@%s", fakeTime)
-	} else {
-		fakeTimeEnv = fakeTime
+	for num, container := range pod.Spec.Containers {
+		opPatches = append(opPatches, volumeMountPatches(fmt.Sprintf("/spec/containers/%d", num), container, mounts...)...)
 	}
 
+	//add container env, libfaketime reads the fake time from the FAKETIME env, or from the spec file if the pod is updated in place.
+	//only one of them is set, the FAKETIME env would shadow the spec file
 	Env := []apiv1.EnvVar{
 		{Name: "LD_PRELOAD", Value: LibFakeTimePath},
 		{Name: "FAKETIME", Value: fakeTimeEnv},
 	}
+	unset := "FAKETIME_TIMESTAMP_FILE"
+	if inPlace {
+		Env[1] = apiv1.EnvVar{Name: "FAKETIME_TIMESTAMP_FILE", Value: SpecFile}
+		unset = "FAKETIME"
+	}
 	for num, c := range pod.Spec.Containers {
-		if len(c.Env) == 0 {
-			ContainerEnvPath = fmt.Sprintf("/spec/containers/%d/env", num)
-			valueContainerEnv = append([]apiv1.EnvVar{}, Env...)
-			patchContainerEnv = true
-		} else {
-			ContainerEnvPath = fmt.Sprintf("/spec/containers/%d/env", num)
-			c.Env = append(c.Env, Env...)
-			valueContainerEnv = append([]apiv1.EnvVar{}, c.Env...)
-			patchContainerEnv = true
-		}
-		if patchContainerEnv {
+		ContainerEnvPath := fmt.Sprintf("/spec/containers/%d/env", num)
+		// replace the env injected before instead of appending it twice
+		existingEnv, removed := removeEnv(c.Env, unset)
+		valueContainerEnv, changed := mergeEnv(existingEnv, Env)
+		if changed || removed {
 			addContainerEnvPatch := utils.PatchOperation{
 				Op:    "add",
 				Path:  ContainerEnvPath,
@@ -259,7 +287,7 @@ func libFakeTimePatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.Patch
 			opPatches = append(opPatches, addContainerEnvPatch)
 		}
 	}
-	return opPatches
+	return opPatches, nil
 }
 
 func validateLibFakeTime(fakeTime string) error {
@@ -274,23 +302,38 @@ func validateLibFakeTime(fakeTime string) error {
 	return nil
 }
 
-func watchMakerPatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchOperation) []utils.PatchOperation {
+func watchMakerPatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchOperation) ([]utils.PatchOperation, error) {
 	var ContainerImageName string
 
+	if hasContainer(pod, ContainerName) || hasInitContainer(pod, ContainerName) {
+		klog.Infof("pod %s/%s already has the %s container, skip adding it again", pod.Namespace, pod.Name, ContainerName)
+		if !utils.IsPodTemplate(pod) && !injectedOnAdmission(pod) {
+			// the sidecar rendered from a workload template carries the delay of the time the template was admitted
+			var err error
+			opPatches, err = refreshDelayPatches(pod, fakeTime, opPatches)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return shareProcessNamespacePatches(pod, opPatches), nil
+	}
+	if pod.Annotations[FakeTimeInjected] == "true" && pod.Annotations[InjectedMode] == ModeWatchMaker {
+		klog.Warningf("pod %s/%s was injected in watchmaker mode but its %s container was removed, adding it again", pod.Namespace, pod.Name, ContainerName)
+	}
+
 	offset, sec, nsec, err := calculateDelayTime(fakeTime)
 	if err != nil {
-		klog.Error("failed to calculate delay time in watchmaker mode, err:", err)
-		return []utils.PatchOperation{}
+		return nil, fmt.Errorf("failed to calculate delay time in watchmaker mode, err: %v", err)
 	}
 	if offset == "-" {
-		klog.Error("Setting future times is currently only supported in watchmaker mode")
-		return []utils.PatchOperation{}
+		return nil, errors.New("setting past times is not supported in watchmaker mode")
 	}
 
 	if image, ok := os.LookupEnv(IMAGE_ENV); ok {
 		ContainerImageName = image
 	}
-	con := apiv1.Container{
+	con := sidecarContainer{}
+	con.Container = apiv1.Container{
 		Image:           ContainerImageName,
 		Name:            ContainerName,
 		ImagePullPolicy: apiv1.PullAlways,
@@ -301,6 +344,23 @@ func watchMakerPatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchO
 		{Name: "delay_nanosecond", Value: strconv.Itoa(nsec)},
 	}
 
+	selectorPatches, selectorEnv, err := processSelectorPatches(pod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid process selector in watchmaker mode: %v", err)
+	}
+	con.Env = append(con.Env, selectorEnv...)
+	opPatches = append(opPatches, selectorPatches...)
+
+	reconcileEnv, err := reconcileSidecarEnv(pod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reconciliation policy in watchmaker mode: %v", err)
+	}
+	con.Env = append(con.Env, reconcileEnv...)
+	// the delay env is the fallback if the spec file is missing
+	con.Env = append(con.Env, apiv1.EnvVar{Name: "spec_file", Value: SpecFile})
+	con.VolumeMounts = []apiv1.VolumeMount{specVolumeMount()}
+	opPatches = append(opPatches, volumePatches(pod, specVolume())...)
+
 OutBreak:
 	for _, container := range pod.Spec.Containers {
 		for _, v := range container.Env {
@@ -311,20 +371,246 @@ OutBreak:
 		}
 	}
 
+	// native sidecar runs the watchmaker as a restartable init container, so that batch jobs can terminate
+	var sidecarPath string
+	var sidecarValue interface{}
+	if useNativeSidecar(pod) {
+		if !nativeSidecarSupported {
+			// older api servers drop the restart policy, the init container would then block the pod forever
+			return nil, fmt.Errorf("native sidecars require Kubernetes %s or later, remove %s or NATIVE_SIDECAR", MinNativeSidecarVersion, NativeSidecar)
+		}
+		// init containers must not have a readiness probe, and a startup probe would hold back the processes to modify
+		con.RestartPolicy = restartPolicyAlways
+		if len(pod.Spec.InitContainers) == 0 {
+			sidecarPath = "/spec/initContainers"
+			sidecarValue = []sidecarContainer{con}
+		} else {
+			sidecarPath = "/spec/initContainers/-"
+			sidecarValue = con
+		}
+	} else {
+		if pod.Annotations[ReadyAfterModified] == "true" {
+			// the sidecar, and so the pod, is ready once every matching process has been modified.
+			// It is opt-in, a failed modification only means real time otherwise, the status file records it
+			con.ReadinessProbe = &apiv1.Probe{
+				ProbeHandler: apiv1.ProbeHandler{
+					Exec: &apiv1.ExecAction{
+						Command: []string{"/bin/bash", "-c", fmt.Sprintf("grep -q modified %[1]s && ! grep -q failed %[1]s", WatchMakerStatusFile)},
+					},
+				},
+				PeriodSeconds: 5,
+			}
+		}
+		sidecarPath = "/spec/containers/-"
+		sidecarValue = con
+	}
 	addSidecarPatch := utils.PatchOperation{
 		Op:    "add",
-		Path:  "/spec/containers/-",
-		Value: con,
+		Path:  sidecarPath,
+		Value: sidecarValue,
 	}
 	opPatches = append(opPatches, addSidecarPatch)
+	return shareProcessNamespacePatches(pod, opPatches), nil
+}
+
+// injectedOnAdmission returns true if the pod was injected earlier in its admission, e.g. on reinvocation of the webhook.
+// Only pods get the spec annotation, the pods rendered from an injected template only carry the marker.
+func injectedOnAdmission(pod *apiv1.Pod) bool {
+	return pod.Annotations[FakeTimeInjected] == "true" && pod.Annotations[FakeTimeSpec] != ""
+}
+
+// refreshDelayPatches resolves the delay of an existing sidecar again at the admission of the pod
+func refreshDelayPatches(pod *apiv1.Pod, fakeTime string, opPatches []utils.PatchOperation) ([]utils.PatchOperation, error) {
+	offset, sec, nsec, err := calculateDelayTime(fakeTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate delay time in watchmaker mode, err: %v", err)
+	}
+	if offset == "-" {
+		return nil, errors.New("setting past times is not supported in watchmaker mode")
+	}
+	env := []apiv1.EnvVar{
+		{Name: "delay_second", Value: strconv.Itoa(sec)},
+		{Name: "delay_nanosecond", Value: strconv.Itoa(nsec)},
+	}
+	for _, field := range []struct {
+		path       string
+		containers []apiv1.Container
+	}{{"/spec/containers", pod.Spec.Containers}, {"/spec/initContainers", pod.Spec.InitContainers}} {
+		for num, c := range field.containers {
+			if c.Name != ContainerName {
+				continue
+			}
+			if delayEnv, changed := mergeEnv(c.Env, env); changed {
+				opPatches = append(opPatches, utils.PatchOperation{
+					Op:    "add",
+					Path:  fmt.Sprintf("%s/%d/env", field.path, num),
+					Value: delayEnv,
+				})
+			}
+		}
+	}
+	return opPatches, nil
+}
+
+func shareProcessNamespacePatches(pod *apiv1.Pod, opPatches []utils.PatchOperation) []utils.PatchOperation {
+	if pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace {
+		return opPatches
+	}
 	var isShareProcessNamespace = true
 	openShareProcessNamespace := utils.PatchOperation{
 		Op:    "add",
 		Path:  "/spec/shareProcessNamespace",
 		Value: &isShareProcessNamespace,
 	}
-	opPatches = append(opPatches, openShareProcessNamespace)
-	return opPatches
+	return append(opPatches, openShareProcessNamespace)
+}
+
+// isWatchMakerMode returns true if any process selector annotation is set
+func isWatchMakerMode(podAnnots map[string]string) bool {
+	for _, key := range []string{ModifyProcessName, ProcessContainer, ProcessCmdline, ProcessPidFile} {
+		if _, ok := podAnnots[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// processSelectorPatches translates the process selector annotations into sidecar env.
+// The target container is marked with the FAKE_TIME_CONTAINER env, so that the sidecar can find its processes in the shared process namespace.
+func processSelectorPatches(pod *apiv1.Pod) ([]utils.PatchOperation, []apiv1.EnvVar, error) {
+	var opPatches []utils.PatchOperation
+	var env []apiv1.EnvVar
+
+	if containerName := pod.Annotations[ProcessContainer]; containerName != "" {
+		num := -1
+		for i, c := range pod.Spec.Containers {
+			if c.Name == containerName {
+				num = i
+				break
+			}
+		}
+		if num < 0 {
+			return nil, nil, fmt.Errorf("container %s is not found in pod", containerName)
+		}
+		marker := apiv1.EnvVar{Name: ContainerMarkerEnv, Value: containerName}
+		if markedEnv, changed := mergeEnv(pod.Spec.Containers[num].Env, []apiv1.EnvVar{marker}); changed {
+			opPatches = append(opPatches, utils.PatchOperation{
+				Op:    "add",
+				Path:  fmt.Sprintf("/spec/containers/%d/env", num),
+				Value: markedEnv,
+			})
+		}
+		env = append(env, apiv1.EnvVar{Name: "modify_container_name", Value: containerName})
+	}
+
+	if cmdline := pod.Annotations[ProcessCmdline]; cmdline != "" {
+		if _, err := regexp.CompilePOSIX(cmdline); err != nil {
+			return nil, nil, fmt.Errorf("invalid cmdline regex %q: %v", cmdline, err)
+		}
+		env = append(env, apiv1.EnvVar{Name: "modify_process_cmdline", Value: cmdline})
+	}
+
+	if pidFile := pod.Annotations[ProcessPidFile]; pidFile != "" {
+		if !strings.HasPrefix(pidFile, "/") {
+			return nil, nil, fmt.Errorf("pid file %s must be an absolute path", pidFile)
+		}
+		env = append(env, apiv1.EnvVar{Name: "modify_process_pidfile", Value: pidFile})
+	}
+	return opPatches, env, nil
+}
+
+// reconcileSidecarEnv resolves the rescan interval and retry policy of the sidecar.
+// The pod annotations take precedence over the env of the injector, a zero interval runs the watchmaker only once.
+func reconcileSidecarEnv(pod *apiv1.Pod) ([]apiv1.EnvVar, error) {
+	interval := "10s"
+	if v, ok := os.LookupEnv(RESCAN_INTERVAL_ENV); ok {
+		interval = v
+	}
+	if v, ok := pod.Annotations[RescanInterval]; ok {
+		interval = v
+	}
+	intervalSeconds, err := parseIntervalSeconds(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := "3"
+	if v, ok := os.LookupEnv(MAX_RETRIES_ENV); ok {
+		retries = v
+	}
+	if v, ok := pod.Annotations[MaxRetries]; ok {
+		retries = v
+	}
+	if n, err := strconv.Atoi(retries); err != nil || n < 0 {
+		return nil, fmt.Errorf("max retries %q must be a non-negative integer", retries)
+	}
+
+	return []apiv1.EnvVar{
+		{Name: "rescan_interval", Value: strconv.Itoa(intervalSeconds)},
+		{Name: "max_retries", Value: retries},
+		{Name: "status_file", Value: WatchMakerStatusFile},
+	}, nil
+}
+
+// parseIntervalSeconds accepts a duration like '30s' or a number of seconds
+func parseIntervalSeconds(interval string) (int, error) {
+	if seconds, err := strconv.Atoi(interval); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("rescan interval %q must not be negative", interval)
+		}
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse rescan interval %q: %v", interval, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("rescan interval %q must not be negative", interval)
+	}
+	// the sidecar sleeps whole seconds, a sub-second interval would turn into 0 and disable the rescans
+	if d > 0 && d < time.Second {
+		return 0, fmt.Errorf("rescan interval %q must be 0 or at least 1s", interval)
+	}
+	return int(math.Ceil(d.Seconds())), nil
+}
+
+// restartPolicyAlways is the container level restart policy that marks an init container as a native sidecar.
+// The field was added in k8s.io/api v0.28, the pinned v0.24 Container lacks it, so it is serialized by sidecarContainer.
+const restartPolicyAlways = "Always"
+
+// MinNativeSidecarVersion is the first Kubernetes version enabling the SidecarContainers feature gate by default
+const MinNativeSidecarVersion = "1.29"
+
+// nativeSidecarSupported is false if the api server is older than MinNativeSidecarVersion
+var nativeSidecarSupported = true
+
+// SetNativeSidecarSupported records whether the api server keeps the restart policy of init containers.
+// It must be called before the webhook serves.
+func SetNativeSidecarSupported(supported bool) {
+	nativeSidecarSupported = supported
+}
+
+type sidecarContainer struct {
+	apiv1.Container
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+}
+
+// useNativeSidecar checks the pod annotation first and falls back to the NATIVE_SIDECAR env of the injector
+func useNativeSidecar(pod *apiv1.Pod) bool {
+	if v, ok := pod.Annotations[NativeSidecar]; ok {
+		return v == "true"
+	}
+	val, ok := os.LookupEnv(NATIVE_SIDECAR_ENV)
+	return ok && val == "true"
+}
+
+// useInPlaceUpdate checks the pod annotation first and falls back to the IN_PLACE_UPDATE env of the injector
+func useInPlaceUpdate(pod *apiv1.Pod) bool {
+	if v, ok := pod.Annotations[InPlaceUpdate]; ok {
+		return v == "true"
+	}
+	val, ok := os.LookupEnv(IN_PLACE_UPDATE_ENV)
+	return ok && val == "true"
 }
 
 func hasInitContainer(pod *apiv1.Pod, initContainerName string) bool {
@@ -336,6 +622,50 @@ func hasInitContainer(pod *apiv1.Pod, initContainerName string) bool {
 	return false
 }
 
+func hasContainer(pod *apiv1.Pod, containerName string) bool {
+	for _, c := range pod.Spec.Containers {
+		if containerName == c.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEnv sets the given env on top of the existing env, and reports whether anything changed
+func mergeEnv(existing []apiv1.EnvVar, env []apiv1.EnvVar) ([]apiv1.EnvVar, bool) {
+	merged := append([]apiv1.EnvVar{}, existing...)
+	changed := false
+	for _, e := range env {
+		found := false
+		for i := range merged {
+			if merged[i].Name == e.Name {
+				found = true
+				if merged[i].Value != e.Value || merged[i].ValueFrom != nil {
+					merged[i] = e
+					changed = true
+				}
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, e)
+			changed = true
+		}
+	}
+	return merged, changed
+}
+
+// removeEnv drops the named env, and reports whether it was set
+func removeEnv(existing []apiv1.EnvVar, name string) ([]apiv1.EnvVar, bool) {
+	kept := make([]apiv1.EnvVar, 0, len(existing))
+	for _, e := range existing {
+		if e.Name != name {
+			kept = append(kept, e)
+		}
+	}
+	return kept, len(kept) != len(existing)
+}
+
 func hasVolume(pod *apiv1.Pod, volumeName string) bool {
 	for _, v := range pod.Spec.Volumes {
 		if v.Name == volumeName {
@@ -472,13 +802,50 @@ func timeStrAddDuration(fakeTime string, offsetTime time.Duration) (newFakeTime
 	return newFakeTime, nil
 }
 
-func removeNamespaceDelayKey(key string) {
-	mu.Lock()
-	defer mu.Unlock()
+// resolveAnchor returns the fake time of the anchor group, the fake time of the first pod opens the anchor window of the group.
+// admit checks the resolved fake time, a rejected fake time does not open an anchor window.
+func resolveAnchor(anchorGroup string, fakeTime string, admit func(resolved string) error) (string, error) {
+	namespaceDelayTimeout := 40 * time.Second
+	if v, _ := os.LookupEnv(NamespaceDelayTimeout); v != "" {
+		timeout, err := strconv.Atoi(v)
+		if err != nil {
+			return "", fmt.Errorf("failed parse %s, err: %v", NamespaceDelayTimeout, err)
+		}
+		namespaceDelayTimeout = time.Duration(timeout) * time.Second
+	}
+	now := time.Now().UTC()
+	candidate := Anchor{Start: now, Value: fakeTime, Expires: now.Add(namespaceDelayTimeout)}
+
+	resolved := fakeTime
+	_, err := anchorStore.LoadOrStore(anchorGroup, candidate, func(anchor Anchor) error {
+		if anchor.Start.Equal(candidate.Start) && anchor.Value == candidate.Value {
+			klog.Infof("set Key: %v, will be deleted after  %v seconds", anchorGroup, namespaceDelayTimeout.Seconds())
+			resolved = fakeTime
+			return admit(resolved)
+		}
+		// If the key already exists, the same anchor group fake time is used directly
+		klog.Infof("Key %q already exists, start time is %v,using value: %s\n", anchorGroup, anchor.Start, anchor.Value)
+		var err error
+		if strings.Contains(anchor.Value, ":") {
+			resolved, err = timeStrAddDuration(anchor.Value, time.Since(anchor.Start))
+		} else {
+			resolved, err = parseOffsetTime(anchor.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to calculate fake time, err: %v", err)
+		}
+		return admit(resolved)
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
 
-	if entry, exists := delaySecondGroup[key]; exists {
-		delete(delaySecondGroup, key)
-		entry.Timeout.Stop()
-		klog.Infof("Key %s has been cleaned\n", key)
+// Stop drops the cluster mode anchors kept by this replica
+func (s *FaketimePlugin) Stop() {
+	if store, ok := anchorStore.(*memoryAnchorStore); ok {
+		store.reset()
 	}
+	metrics.ClusterModeAnchors.Set(0)
 }
@@ -0,0 +1,516 @@
+package faketime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	addmissionV1 "k8s.io/api/admission/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPatchIsIdempotent(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		pod         apiv1.PodSpec
+	}{
+		{
+			name:        "libfaketime",
+			annotations: map[string]string{FakeTime: "+1h"},
+			pod:         apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}, {Name: "log"}}},
+		},
+		{
+			name:        "libfaketime with existing init containers, env and volumes",
+			annotations: map[string]string{FakeTime: "-2d"},
+			pod: apiv1.PodSpec{
+				InitContainers: []apiv1.Container{{Name: "init"}},
+				Containers: []apiv1.Container{{
+					Name:         "app",
+					Env:          []apiv1.EnvVar{{Name: "FOO", Value: "bar"}},
+					VolumeMounts: []apiv1.VolumeMount{{Name: "data", MountPath: "/data"}},
+				}},
+				Volumes: []apiv1.Volume{{Name: "data"}},
+			},
+		},
+		{
+			name:        "libfaketime updated in place",
+			annotations: map[string]string{FakeTime: "2030-01-01 00:00:00", InPlaceUpdate: "true"},
+			pod:         apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		},
+		{
+			name:        "watchmaker",
+			annotations: map[string]string{FakeTime: "3600", ModifyProcessName: "game"},
+			pod:         apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		},
+		{
+			name:        "watchmaker with a container selector",
+			annotations: map[string]string{FakeTime: "86400", ProcessContainer: "app", ProcessCmdline: "server"},
+			pod:         apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}, {Name: "proxy"}}},
+		},
+		{
+			name:        "watchmaker as native sidecar",
+			annotations: map[string]string{FakeTime: "1800.5", ModifyProcessName: "game", NativeSidecar: "true"},
+			pod:         apiv1.PodSpec{InitContainers: []apiv1.Container{{Name: "init"}}, Containers: []apiv1.Container{{Name: "app"}}},
+		},
+	}
+
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Annotations: tt.annotations},
+				Spec:       tt.pod,
+			}
+			patches, err := plugin.Patch(pod, addmissionV1.Create)
+			if err != nil {
+				t.Fatalf("first invocation failed: %v", err)
+			}
+			if len(patches) == 0 {
+				t.Fatal("first invocation returned no patches")
+			}
+			injected := applyPatches(t, pod, patches)
+			assertUnique(t, injected)
+
+			// the webhook is invoked again, e.g. by reinvocationPolicy: IfNeeded
+			patches, err = plugin.Patch(injected, addmissionV1.Create)
+			if err != nil {
+				t.Fatalf("second invocation failed: %v", err)
+			}
+			reinjected := applyPatches(t, injected, patches)
+			assertUnique(t, reinjected)
+			if !reflect.DeepEqual(injected, reinjected) {
+				t.Errorf("second invocation changed the pod with %+v", patches)
+			}
+		})
+	}
+}
+
+func TestPatchUsesTheInjectedMarker(t *testing.T) {
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Annotations: map[string]string{FakeTime: "3600", ModifyProcessName: "game"}},
+		Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+	}
+	patches, err := plugin.Patch(pod, addmissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injected := applyPatches(t, pod, patches)
+	sidecar := len(injected.Spec.Containers) - 1
+
+	// the delay resolved earlier in the admission is kept on reinvocation
+	reinvoked := injected.DeepCopy()
+	reinvoked.Spec.Containers[sidecar].Env = append([]apiv1.EnvVar{}, reinvoked.Spec.Containers[sidecar].Env...)
+	for i, e := range reinvoked.Spec.Containers[sidecar].Env {
+		if e.Name == "delay_second" {
+			reinvoked.Spec.Containers[sidecar].Env[i].Value = "3590"
+		}
+	}
+	if patches, err = plugin.Patch(reinvoked, addmissionV1.Create); err != nil || len(patches) != 0 {
+		t.Errorf("expected no patches on reinvocation, got %+v, %v", patches, err)
+	}
+
+	// the pod rendered from an injected template only carries the marker, its delay is resolved again
+	rendered := reinvoked.DeepCopy()
+	delete(rendered.Annotations, FakeTimeSpec)
+	delete(rendered.Annotations, EffectiveOffset)
+	patches, err = plugin.Patch(rendered, addmissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay := envOf(applyPatches(t, rendered, patches).Spec.Containers[sidecar], "delay_second"); delay != "3600" {
+		t.Errorf("expected the delay of the rendered pod to be resolved again, got %s", delay)
+	}
+
+	// the sidecar removed after the injection is added again
+	removed := injected.DeepCopy()
+	removed.Spec.Containers = removed.Spec.Containers[:sidecar]
+	patches, err = plugin.Patch(removed, addmissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored := applyPatches(t, removed, patches); !hasContainer(restored, ContainerName) {
+		t.Errorf("expected the removed sidecar to be added again, got %+v", patches)
+	}
+}
+
+func TestPatchUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		fakeTimeEnv string
+		restart     bool
+		expectSpec  string
+	}{
+		{name: "libfaketime", annotations: map[string]string{FakeTime: "+1h"}, fakeTimeEnv: "+1h", restart: true},
+		{name: "libfaketime at an absolute time", annotations: map[string]string{FakeTime: "2030-01-01 00:00:00"}, fakeTimeEnv: "@2030-01-01 00:00:00", restart: true},
+		{name: "libfaketime updated in place", annotations: map[string]string{FakeTime: "+1h", InPlaceUpdate: "true"}, expectSpec: "+2h"},
+		{name: "watchmaker", annotations: map[string]string{FakeTime: "3600", ModifyProcessName: "game", RescanInterval: "30s"}, expectSpec: "7200.000000000"},
+		{name: "watchmaker without rescans", annotations: map[string]string{FakeTime: "3600", ModifyProcessName: "game", RescanInterval: "0"}, restart: true},
+	}
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Annotations: tt.annotations},
+				Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+			}
+			patches, err := plugin.Patch(pod, addmissionV1.Create)
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldPod := applyPatches(t, pod, patches)
+			if tt.fakeTimeEnv != "" {
+				if env := envOf(oldPod.Spec.Containers[0], "FAKETIME"); env != tt.fakeTimeEnv {
+					t.Errorf("expected FAKETIME %q, got %q", tt.fakeTimeEnv, env)
+				}
+				if env := envOf(oldPod.Spec.Containers[0], "FAKETIME_TIMESTAMP_FILE"); env != "" {
+					t.Errorf("expected no spec file without %s, got %q", InPlaceUpdate, env)
+				}
+			}
+
+			newPod := oldPod.DeepCopy()
+			if isWatchMakerMode(tt.annotations) {
+				newPod.Annotations[FakeTime] = "7200"
+			} else {
+				newPod.Annotations[FakeTime] = "+2h"
+			}
+			patches, err = plugin.PatchUpdate(oldPod, newPod)
+			if tt.restart {
+				if !errors.Is(err, utils.ErrRestartRequired) {
+					t.Errorf("expected a restart to be required, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec := applyPatches(t, newPod, patches).Annotations[FakeTimeSpec]; spec != tt.expectSpec {
+				t.Errorf("expected spec %q, got %q", tt.expectSpec, spec)
+			}
+		})
+	}
+}
+
+func envOf(c apiv1.Container, name string) string {
+	for _, e := range c.Env {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+func TestSidecarReadinessIsOptIn(t *testing.T) {
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	for _, ready := range []bool{false, true} {
+		annotations := map[string]string{FakeTime: "3600", ModifyProcessName: "game"}
+		if ready {
+			annotations[ReadyAfterModified] = "true"
+		}
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "game", Namespace: "default", Annotations: annotations},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		}
+		patches, err := plugin.Patch(pod, addmissionV1.Create)
+		if err != nil {
+			t.Fatal(err)
+		}
+		injected := applyPatches(t, pod, patches)
+		for _, c := range injected.Spec.Containers {
+			if c.Name == ContainerName && (c.ReadinessProbe != nil) != ready {
+				t.Errorf("%s=%v: expected readiness probe %v, got %+v", ReadyAfterModified, ready, ready, c.ReadinessProbe)
+			}
+		}
+	}
+}
+
+func TestNativeSidecar(t *testing.T) {
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	defer SetNativeSidecarSupported(true)
+	newPod := func() *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", Annotations: map[string]string{FakeTime: "3600", ModifyProcessName: "game", NativeSidecar: "true"}},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		}
+	}
+
+	patches, err := plugin.Patch(newPod(), addmissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injected := applyPatches(t, newPod(), patches)
+	if len(injected.Spec.InitContainers) != 1 || injected.Spec.InitContainers[0].Name != ContainerName {
+		t.Fatalf("expected the sidecar as init container, got %+v", injected.Spec.InitContainers)
+	}
+	if injected.Spec.InitContainers[0].ReadinessProbe != nil {
+		t.Error("init containers must not have a readiness probe")
+	}
+
+	SetNativeSidecarSupported(false)
+	if _, err := plugin.Patch(newPod(), addmissionV1.Create); err == nil {
+		t.Error("expected native sidecars to be rejected on older api servers")
+	}
+	if issues := plugin.Validate(newPod()); len(issues) == 0 {
+		t.Error("expected a validation error on older api servers")
+	}
+}
+
+func TestParseIntervalSeconds(t *testing.T) {
+	tests := []struct {
+		interval string
+		seconds  int
+		invalid  bool
+	}{
+		{interval: "0", seconds: 0},
+		{interval: "30", seconds: 30},
+		{interval: "0s", seconds: 0},
+		{interval: "1m", seconds: 60},
+		{interval: "1500ms", seconds: 2},
+		{interval: "500ms", invalid: true},
+		{interval: "-1", invalid: true},
+		{interval: "-1s", invalid: true},
+		{interval: "soon", invalid: true},
+	}
+	for _, tt := range tests {
+		seconds, err := parseIntervalSeconds(tt.interval)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", tt.interval, seconds)
+			}
+			continue
+		}
+		if err != nil || seconds != tt.seconds {
+			t.Errorf("%q: expected %d, got %d %v", tt.interval, tt.seconds, seconds, err)
+		}
+	}
+}
+
+func TestPatchRejectsInvalidFakeTime(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "libfaketime offset without sign", annotations: map[string]string{FakeTime: "1h"}},
+		{name: "libfaketime malformed time", annotations: map[string]string{FakeTime: "2024-13-01 00:00:00"}},
+		{name: "watchmaker offset with unit", annotations: map[string]string{FakeTime: "-1h", ModifyProcessName: "game"}},
+	}
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Annotations: tt.annotations},
+				Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+			}
+			if _, err := plugin.Patch(pod, addmissionV1.Create); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestClusterModeAnchorIsSharedByConcurrentAdmissions(t *testing.T) {
+	t.Setenv(CLUSTER_MODE_ENV, "true")
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+
+	const pods = 50
+	var wg sync.WaitGroup
+	pending := make([]*apiv1.Pod, pods)
+	results := make([][]utils.PatchOperation, pods)
+	for i := 0; i < pods; i++ {
+		// the first pod opens the anchor window of the namespace, the others must get its offset
+		fakeTime := "+1h"
+		if i > 0 {
+			fakeTime = fmt.Sprintf("+%dh", i+1)
+		}
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "anchor", Annotations: map[string]string{FakeTime: fakeTime}},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		}
+		if i == 0 {
+			if _, err := plugin.Patch(pod, addmissionV1.Create); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		pending[i] = pod
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			patches, err := plugin.Patch(pending[i], addmissionV1.Create)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = patches
+		}(i)
+	}
+	wg.Wait()
+	offsets := make([]string, pods)
+	for i := 1; i < pods; i++ {
+		offsets[i] = applyPatches(t, pending[i], results[i]).Annotations[EffectiveOffset]
+	}
+	if offsets[1] == "" {
+		t.Fatal("the effective offset is not recorded")
+	}
+	for i := 1; i < pods; i++ {
+		if offsets[i] != offsets[1] {
+			t.Errorf("pod-%d got the offset %s, pod-1 got %s", i, offsets[i], offsets[1])
+		}
+	}
+}
+
+func TestClusterModePolicyChecksTheAnchor(t *testing.T) {
+	t.Setenv(CLUSTER_MODE_ENV, "true")
+	t.Setenv(MAX_OFFSET_ENV, "2h")
+	plugin := NewSgPlugin()
+	defer plugin.Stop()
+
+	newPod := func(name, fakeTime string) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "policy", Annotations: map[string]string{FakeTime: fakeTime}},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "app"}}},
+		}
+	}
+	// a violating pod neither gets injected nor opens the anchor window
+	if _, err := plugin.Patch(newPod("violating", "+1d"), addmissionV1.Create); !errors.Is(err, utils.ErrPolicyViolation) {
+		t.Fatalf("expected a policy violation, got %v", err)
+	}
+	if _, err := plugin.Patch(newPod("first", "+1h"), addmissionV1.Create); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(MAX_OFFSET_ENV, "30m")
+	// the compliant annotation of the second pod is replaced by the anchor, which violates the tightened policy
+	if _, err := plugin.Patch(newPod("second", "+10m"), addmissionV1.Create); !errors.Is(err, utils.ErrPolicyViolation) {
+		t.Errorf("expected the anchor to violate the policy, got %v", err)
+	}
+}
+
+// assertUnique fails if a container, an env or a volume is added twice
+func assertUnique(t *testing.T, pod *apiv1.Pod) {
+	t.Helper()
+	seen := map[string]bool{}
+	check := func(kind string, name string) {
+		if seen[kind+"/"+name] {
+			t.Errorf("duplicate %s %s", kind, name)
+		}
+		seen[kind+"/"+name] = true
+	}
+	for _, vol := range pod.Spec.Volumes {
+		check("volume", vol.Name)
+	}
+	for _, c := range append(append([]apiv1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		check("container", c.Name)
+		for _, env := range c.Env {
+			check("env of "+c.Name, env.Name)
+		}
+		for _, vm := range c.VolumeMounts {
+			check("volume mount of "+c.Name, vm.Name)
+		}
+	}
+}
+
+// applyPatches applies the add operations emitted by the plugin to a copy of the pod
+func applyPatches(t *testing.T, pod *apiv1.Pod, patches []utils.PatchOperation) *apiv1.Pod {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range patches {
+		if op.Op != "add" {
+			t.Fatalf("unexpected operation %s %s", op.Op, op.Path)
+		}
+		value, err := roundTrip(op.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tokens []string
+		for _, token := range strings.Split(strings.TrimPrefix(op.Path, "/"), "/") {
+			tokens = append(tokens, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+		}
+		if obj, err = add(obj, tokens, value); err != nil {
+			t.Fatalf("failed to apply %s: %v", op.Path, err)
+		}
+	}
+	raw, err = json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := &apiv1.Pod{}
+	if err := json.Unmarshal(raw, patched); err != nil {
+		t.Fatal(err)
+	}
+	return patched
+}
+
+func roundTrip(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(raw, &decoded)
+	return decoded, err
+}
+
+func add(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	key := tokens[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(tokens) == 1 {
+			n[key] = value
+			return n, nil
+		}
+		child, ok := n[key]
+		if !ok {
+			return nil, fmt.Errorf("%s is not found", key)
+		}
+		child, err := add(child, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = child
+		return n, nil
+	case []interface{}:
+		if key == "-" && len(tokens) == 1 {
+			return append(n, value), nil
+		}
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx >= len(n) {
+			return nil, fmt.Errorf("invalid index %s", key)
+		}
+		if len(tokens) == 1 {
+			n = append(n[:idx], append([]interface{}{value}, n[idx:]...)...)
+			return n, nil
+		}
+		child, err := add(n[idx], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%s is not an object or array", key)
+	}
+}
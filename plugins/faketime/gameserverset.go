@@ -0,0 +1,35 @@
+package faketime
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+const (
+	// GameServerSetOwnerLabel is set by OpenKruise kruise-game on the pods of a GameServerSet
+	GameServerSetOwnerLabel = "game.kruise.io/owner-gss"
+	GameServerSetKind       = "GameServerSet"
+	GameServerSetGroup      = "game.kruise.io"
+)
+
+// gameServerSetOf returns the name of the GameServerSet owning the pod, or an empty string.
+// kruise-game creates the pods through an Advanced StatefulSet, so the owner label is checked before the owner references.
+func gameServerSetOf(pod *apiv1.Pod) string {
+	if name := pod.Labels[GameServerSetOwnerLabel]; name != "" {
+		return name
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == GameServerSetKind && strings.HasPrefix(owner.APIVersion, GameServerSetGroup+"/") {
+			return owner.Name
+		}
+	}
+	return ""
+}
+
+// anchorGroupOf returns the cluster mode anchor group of the pod, the pods of a GameServerSet share the same fake time
+func anchorGroupOf(pod *apiv1.Pod) string {
+	if gss := gameServerSetOf(pod); gss != "" {
+		return pod.Namespace + "/" + gss
+	}
+	return pod.Namespace
+}
@@ -0,0 +1,216 @@
+package faketime
+
+import (
+	"errors"
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// specVolume projects the resolved spec annotation into the pod, the kubelet refreshes the file when the annotation changes
+func specVolume() apiv1.Volume {
+	return apiv1.Volume{
+		Name: SpecVolumeName,
+		VolumeSource: apiv1.VolumeSource{
+			DownwardAPI: &apiv1.DownwardAPIVolumeSource{
+				Items: []apiv1.DownwardAPIVolumeFile{
+					{
+						Path: "spec",
+						FieldRef: &apiv1.ObjectFieldSelector{
+							FieldPath: fmt.Sprintf("metadata.annotations['%s']", FakeTimeSpec),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func specVolumeMount() apiv1.VolumeMount {
+	return apiv1.VolumeMount{
+		Name:      SpecVolumeName,
+		MountPath: SpecMountPath,
+		ReadOnly:  true,
+	}
+}
+
+// resolveSpec converts the fake time to the spec read by the running pod.
+// watchmaker reads the delay as '<sec>.<nsec>'. libfaketime reads a FAKETIME value, a pod being created starts at the
+// absolute time, a running pod is moved by the offset to the absolute time instead.
+func resolveSpec(mode string, fakeTime string, create bool) (string, error) {
+	switch mode {
+	case ModeWatchMaker:
+		offset, sec, nsec, err := calculateDelayTime(fakeTime)
+		if err != nil {
+			return "", err
+		}
+		if offset == "-" {
+			return "", errors.New("setting past times is not supported in watchmaker mode")
+		}
+		return fmt.Sprintf("%d.%09d", sec, nsec), nil
+	case ModeLibFakeTime:
+		if err := validateLibFakeTime(fakeTime); err != nil {
+			return "", err
+		}
+		if !strings.Contains(fakeTime, ":") {
+			return fakeTime, nil
+		}
+		if create {
+			return "@" + fakeTime, nil
+		}
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", fakeTime)
+		if err != nil {
+			return "", err
+		}
+		seconds := int64(time.Until(t).Round(time.Second).Seconds())
+		if seconds >= 0 {
+			return "+" + strconv.FormatInt(seconds, 10), nil
+		}
+		return strconv.FormatInt(seconds, 10), nil
+	default:
+		return "", fmt.Errorf("unknown injected mode %q", mode)
+	}
+}
+
+// sidecarAnnotations are read by the watchmaker sidecar at startup, they can not change in place
+var sidecarAnnotations = []string{ModifyProcessName, ProcessContainer, ProcessCmdline, ProcessPidFile, RescanInterval, MaxRetries, NativeSidecar, ReadyAfterModified}
+
+// PatchUpdate changes the fake time of a running pod in place, e.g. on an InPlaceIfPossible update of a GameServerSet.
+// Only the annotations of a running pod can be changed, the pod reads the new spec from the downward API volume.
+// Changes which need new containers or env are reported as ErrRestartRequired.
+func (s *FaketimePlugin) PatchUpdate(oldPod *apiv1.Pod, pod *apiv1.Pod) ([]utils.PatchOperation, error) {
+	fakeTime := pod.Annotations[FakeTime]
+	oldFakeTime := oldPod.Annotations[FakeTime]
+	if pod.Annotations[FakeTimeInjected] != "true" {
+		if fakeTime != "" && fakeTime != oldFakeTime {
+			return nil, fmt.Errorf("%w: fake time %q was set after the pod was created, recreate the pod to inject it", utils.ErrRestartRequired, fakeTime)
+		}
+		return nil, nil
+	}
+
+	mode := pod.Annotations[InjectedMode]
+	if mode == ModeWatchMaker {
+		for _, key := range sidecarAnnotations {
+			if pod.Annotations[key] != oldPod.Annotations[key] {
+				return nil, fmt.Errorf("%w: annotation %s of the pod injected in watchmaker mode changed, recreate the pod to apply it", utils.ErrRestartRequired, key)
+			}
+		}
+	} else if isWatchMakerMode(pod.Annotations) && !isWatchMakerMode(oldPod.Annotations) {
+		return nil, fmt.Errorf("%w: process selectors were added to the pod injected in %s mode, recreate the pod to switch to watchmaker mode", utils.ErrRestartRequired, mode)
+	}
+	if fakeTime == oldFakeTime {
+		return nil, nil
+	}
+	if !hasVolume(pod, SpecVolumeName) {
+		if mode == ModeLibFakeTime {
+			return nil, fmt.Errorf("%w: pod was injected in libfaketime mode without %s=true, recreate it to change the fake time", utils.ErrRestartRequired, InPlaceUpdate)
+		}
+		return nil, fmt.Errorf("%w: pod was injected without the %s volume, recreate it to change the fake time", utils.ErrRestartRequired, SpecVolumeName)
+	}
+	if mode == ModeWatchMaker && sidecarRescanInterval(pod) <= 0 {
+		// the sidecar exits after the first pass and never reads the spec again
+		return nil, fmt.Errorf("%w: the watchmaker sidecar of the pod does not rescan, recreate it to change the fake time", utils.ErrRestartRequired)
+	}
+
+	var policyErr error
+	if fakeTime != "" {
+		policyErr = enforcePolicy(pod, fakeTime)
+		if errors.Is(policyErr, utils.ErrPolicyViolation) {
+			return nil, policyErr
+		}
+	}
+
+	annotations := map[string]string{}
+	if fakeTime == "" {
+		// the annotation is removed, the running pod goes back to the real time
+		annotations[FakeTimeSpec] = zeroSpec(mode)
+		annotations[EffectiveOffset] = "+0s"
+	} else {
+		spec, err := resolveSpec(mode, fakeTime, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid faketime in %s mode: %v", mode, err)
+		}
+		annotations[FakeTimeSpec] = spec
+		if offset, err := effectiveOffset(fakeTime); err == nil {
+			annotations[EffectiveOffset] = offset
+		}
+	}
+	klog.Infof("pod %s/%s changes the fake time in place from %q to %q", pod.Namespace, pod.Name, oldFakeTime, fakeTime)
+	return annotationPatches(pod, annotations), policyErr
+}
+
+// sidecarRescanInterval returns the rescan interval the watchmaker sidecar of the pod was started with
+func sidecarRescanInterval(pod *apiv1.Pod) int {
+	containers := append(append([]apiv1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Name != ContainerName {
+			continue
+		}
+		for _, e := range c.Env {
+			if e.Name == "rescan_interval" {
+				interval, err := strconv.Atoi(e.Value)
+				if err != nil {
+					return 0
+				}
+				return interval
+			}
+		}
+		// start.sh does not rescan without the env
+		return 0
+	}
+	return 0
+}
+
+// zeroSpec is the spec of the real time
+func zeroSpec(mode string) string {
+	if mode == ModeWatchMaker {
+		return "0.000000000"
+	}
+	return "+0"
+}
+
+// volumePatches adds the missing volumes to the pod
+func volumePatches(pod *apiv1.Pod, vols ...apiv1.Volume) []utils.PatchOperation {
+	var missing []apiv1.Volume
+	for _, vol := range vols {
+		if !hasVolume(pod, vol.Name) {
+			missing = append(missing, vol)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(pod.Spec.Volumes) == 0 {
+		return []utils.PatchOperation{{Op: "add", Path: "/spec/volumes", Value: missing}}
+	}
+	var opPatches []utils.PatchOperation
+	for _, vol := range missing {
+		opPatches = append(opPatches, utils.PatchOperation{Op: "add", Path: "/spec/volumes/-", Value: vol})
+	}
+	return opPatches
+}
+
+// volumeMountPatches adds the missing volume mounts to the container at containerPath, e.g. /spec/containers/0
+func volumeMountPatches(containerPath string, container apiv1.Container, mounts ...apiv1.VolumeMount) []utils.PatchOperation {
+	var missing []apiv1.VolumeMount
+	for _, vm := range mounts {
+		if !hasVolumeMount(container, vm.Name) {
+			missing = append(missing, vm)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(container.VolumeMounts) == 0 {
+		return []utils.PatchOperation{{Op: "add", Path: containerPath + "/volumeMounts", Value: missing}}
+	}
+	var opPatches []utils.PatchOperation
+	for _, vm := range missing {
+		opPatches = append(opPatches, utils.PatchOperation{Op: "add", Path: containerPath + "/volumeMounts/-", Value: vm})
+	}
+	return opPatches
+}
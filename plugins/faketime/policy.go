@@ -0,0 +1,154 @@
+package faketime
+
+import (
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the largest offset from the real time, e.g. 30d
+	MAX_OFFSET_ENV = "FAKETIME_MAX_OFFSET"
+	// comma separated namespaces or patterns like payment-*, which never get fake time
+	FORBIDDEN_NAMESPACES_ENV = "FAKETIME_FORBIDDEN_NAMESPACES"
+	// comma separated labels like env=production, or label keys, of the pods which never get fake time
+	FORBIDDEN_LABELS_ENV = "FAKETIME_FORBIDDEN_LABELS"
+	// semicolon separated windows of the permitted fake times, e.g. '2024-01-01 00:00:00/2024-03-31 23:59:59'
+	ALLOWED_WINDOWS_ENV = "FAKETIME_ALLOWED_WINDOWS"
+	// deny skips the injection of the violating pods, warn injects them with a warning
+	POLICY_ACTION_ENV = "FAKETIME_POLICY_ACTION"
+	PolicyActionDeny  = "deny"
+	PolicyActionWarn  = "warn"
+)
+
+// policyAction returns the action on policy violations, deny by default
+func policyAction() string {
+	if v, _ := os.LookupEnv(POLICY_ACTION_ENV); v == PolicyActionWarn {
+		return PolicyActionWarn
+	}
+	return PolicyActionDeny
+}
+
+// checkPolicy returns the reason if the fake time of the pod violates the safety policy, a malformed policy is a violation as well
+func checkPolicy(pod *apiv1.Pod, fakeTime string) string {
+	if v := os.Getenv(FORBIDDEN_NAMESPACES_ENV); v != "" && pod.Namespace != "" {
+		for _, pattern := range strings.Split(v, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if matched, _ := path.Match(pattern, pod.Namespace); pattern != "" && matched {
+				return fmt.Sprintf("namespace %s is forbidden by %s=%s", pod.Namespace, FORBIDDEN_NAMESPACES_ENV, v)
+			}
+		}
+	}
+	if v := os.Getenv(FORBIDDEN_LABELS_ENV); v != "" {
+		for _, label := range strings.Split(v, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(label), "=")
+			podValue, ok := pod.Labels[key]
+			if key != "" && ok && (!hasValue || podValue == value) {
+				return fmt.Sprintf("label %s=%s is forbidden by %s=%s", key, podValue, FORBIDDEN_LABELS_ENV, v)
+			}
+		}
+	}
+
+	maxOffset := os.Getenv(MAX_OFFSET_ENV)
+	windows := os.Getenv(ALLOWED_WINDOWS_ENV)
+	if maxOffset == "" && windows == "" {
+		return ""
+	}
+	offset, target, err := resolveTarget(fakeTime)
+	if err != nil {
+		// invalid fake times are reported by the patches
+		return ""
+	}
+	if maxOffset != "" {
+		limit, err := offsetSeconds(maxOffset)
+		if err != nil {
+			return fmt.Sprintf("invalid %s=%s: %v", MAX_OFFSET_ENV, maxOffset, err)
+		}
+		if math.Abs(offset.Seconds()) > math.Abs(limit) {
+			return fmt.Sprintf("offset %s exceeds %s=%s", offset.Round(time.Second), MAX_OFFSET_ENV, maxOffset)
+		}
+	}
+	if windows != "" {
+		allowed, err := inWindows(windows, target)
+		if err != nil {
+			return fmt.Sprintf("invalid %s=%s: %v", ALLOWED_WINDOWS_ENV, windows, err)
+		}
+		if !allowed {
+			return fmt.Sprintf("fake time %s is outside of %s=%s", target.Format("2006-01-02 15:04:05"), ALLOWED_WINDOWS_ENV, windows)
+		}
+	}
+	return ""
+}
+
+// enforcePolicy returns ErrPolicyViolation if the pod must not be injected, ErrPolicyWarning if it is injected anyway
+func enforcePolicy(pod *apiv1.Pod, fakeTime string) error {
+	reason := checkPolicy(pod, fakeTime)
+	if reason == "" {
+		return nil
+	}
+	if policyAction() == PolicyActionWarn {
+		klog.Warningf("pod %s/%s violates the fake time policy, inject it anyway: %s", pod.Namespace, pod.Name, reason)
+		return fmt.Errorf("%w: %s", utils.ErrPolicyWarning, reason)
+	}
+	return fmt.Errorf("%w: %s", utils.ErrPolicyViolation, reason)
+}
+
+// resolveTarget returns the offset from now and the fake time of now, for an absolute fake time or a relative offset
+func resolveTarget(fakeTime string) (time.Duration, time.Time, error) {
+	now := time.Now().UTC()
+	if strings.Contains(fakeTime, ":") {
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", fakeTime)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return t.Sub(now), t, nil
+	}
+	seconds, err := offsetSeconds(fakeTime)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	offset := time.Duration(seconds * float64(time.Second))
+	return offset, now.Add(offset), nil
+}
+
+// offsetSeconds converts an offset like '-1d2h' or '3600' to seconds
+func offsetSeconds(offset string) (float64, error) {
+	resolved, err := parseOffsetTime(offset)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resolved, 64)
+}
+
+// inWindows returns true if t is in any of the windows 'start/end;start/end'
+func inWindows(windows string, t time.Time) (bool, error) {
+	for _, window := range strings.Split(windows, ";") {
+		window = strings.TrimSpace(window)
+		if window == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(window, "/")
+		if !ok {
+			return false, fmt.Errorf("window %q must be start/end", window)
+		}
+		startTime, err := time.Parse("2006-01-02 15:04:05.999999999", strings.TrimSpace(start))
+		if err != nil {
+			return false, err
+		}
+		endTime, err := time.Parse("2006-01-02 15:04:05.999999999", strings.TrimSpace(end))
+		if err != nil {
+			return false, err
+		}
+		if !t.Before(startTime) && !t.After(endTime) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,87 @@
+package faketime
+
+import (
+	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// Annotations returns the annotations known by the plugin, including the ones recorded by the injector
+func (s *FaketimePlugin) Annotations() []string {
+	return []string{FakeTime, ModifyProcessName, ProcessContainer, ProcessCmdline, ProcessPidFile, RescanInterval, MaxRetries, NativeSidecar,
+		ReadyAfterModified, InPlaceUpdate, FakeTimeInjected, InjectedMode, EffectiveOffset, AnchorGroup, FakeTimeSpec}
+}
+
+// Validate checks the annotations of a pod or a pod template the same way as Patch does, without patching it
+func (s *FaketimePlugin) Validate(pod *apiv1.Pod) []utils.ValidationIssue {
+	var issues []utils.ValidationIssue
+	fakeTime := pod.Annotations[FakeTime]
+	if fakeTime == "" {
+		for _, key := range sidecarAnnotations {
+			if _, ok := pod.Annotations[key]; ok {
+				issues = append(issues, utils.ValidationIssue{Annotation: key, Severity: utils.SeverityWarning,
+					Message: fmt.Sprintf("has no effect without %s", FakeTime)})
+			}
+		}
+		return issues
+	}
+
+	if reason := checkPolicy(pod, fakeTime); reason != "" {
+		severity := utils.SeverityError
+		if policyAction() == PolicyActionWarn {
+			severity = utils.SeverityWarning
+		}
+		issues = append(issues, utils.ValidationIssue{Annotation: FakeTime, Severity: severity,
+			Message: "violates the fake time policy: " + reason})
+	}
+
+	if isWatchMakerMode(pod.Annotations) {
+		if _, err := resolveSpec(ModeWatchMaker, fakeTime, true); err != nil {
+			issues = append(issues, utils.ValidationIssue{Annotation: FakeTime, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("invalid fake time %q in watchmaker mode: %v", fakeTime, err)})
+		}
+		if _, _, err := processSelectorPatches(pod); err != nil {
+			issues = append(issues, utils.ValidationIssue{Annotation: ProcessContainer, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("invalid process selector: %v", err)})
+		}
+		if _, err := reconcileSidecarEnv(pod); err != nil {
+			issues = append(issues, utils.ValidationIssue{Annotation: RescanInterval, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("invalid reconciliation policy: %v", err)})
+		}
+		if v, ok := pod.Annotations[NativeSidecar]; ok && v != "true" && v != "false" {
+			issues = append(issues, utils.ValidationIssue{Annotation: NativeSidecar, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("must be \"true\" or \"false\", got %q", v)})
+		}
+		if v, ok := pod.Annotations[ReadyAfterModified]; ok && v != "true" && v != "false" {
+			issues = append(issues, utils.ValidationIssue{Annotation: ReadyAfterModified, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("must be \"true\" or \"false\", got %q", v)})
+		} else if v == "true" && useNativeSidecar(pod) {
+			issues = append(issues, utils.ValidationIssue{Annotation: ReadyAfterModified, Severity: utils.SeverityWarning,
+				Message: "has no effect on native sidecars, init containers have no readiness probe"})
+		}
+		if useNativeSidecar(pod) && !nativeSidecarSupported {
+			issues = append(issues, utils.ValidationIssue{Annotation: NativeSidecar, Severity: utils.SeverityError,
+				Message: fmt.Sprintf("native sidecars require Kubernetes %s or later", MinNativeSidecarVersion)})
+		}
+		return issues
+	}
+
+	if _, err := resolveSpec(ModeLibFakeTime, fakeTime, true); err != nil {
+		issues = append(issues, utils.ValidationIssue{Annotation: FakeTime, Severity: utils.SeverityError,
+			Message: fmt.Sprintf("invalid fake time %q in libfaketime mode: %v", fakeTime, err)})
+	} else if _, err := effectiveOffset(fakeTime); err != nil {
+		issues = append(issues, utils.ValidationIssue{Annotation: FakeTime, Severity: utils.SeverityError,
+			Message: fmt.Sprintf("invalid time offset %q: %v", fakeTime, err)})
+	}
+	if v, ok := pod.Annotations[InPlaceUpdate]; ok && v != "true" && v != "false" {
+		issues = append(issues, utils.ValidationIssue{Annotation: InPlaceUpdate, Severity: utils.SeverityError,
+			Message: fmt.Sprintf("must be \"true\" or \"false\", got %q", v)})
+	}
+	for _, key := range []string{RescanInterval, MaxRetries, NativeSidecar, ReadyAfterModified} {
+		if _, ok := pod.Annotations[key]; ok {
+			issues = append(issues, utils.ValidationIssue{Annotation: key, Severity: utils.SeverityWarning,
+				Message: "only applies to the watchmaker mode, set a process selector like " + ModifyProcessName})
+		}
+	}
+	return issues
+}
@@ -9,5 +9,24 @@ import (
 type Plugin interface {
 	Name() string
 	MatchAnnotations(map[string]string) bool
-	Patch(*apiv1.Pod, v1.Operation) []utils.PatchOperation
+	// Patch returns the patches of a matched pod, an error means the pod has an invalid spec,
+	// unless it wraps utils.ErrPolicyWarning which is returned together with the patches
+	Patch(*apiv1.Pod, v1.Operation) ([]utils.PatchOperation, error)
+}
+
+// Stopper is implemented by plugins which run background work
+type Stopper interface {
+	Stop()
+}
+
+// Updater is implemented by plugins which patch running pods in place on update
+type Updater interface {
+	PatchUpdate(oldPod *apiv1.Pod, pod *apiv1.Pod) ([]utils.PatchOperation, error)
+}
+
+// Validator is implemented by plugins which can check the annotations of a pod without patching it
+type Validator interface {
+	// Annotations returns the annotations known by the plugin
+	Annotations() []string
+	Validate(*apiv1.Pod) []utils.ValidationIssue
 }
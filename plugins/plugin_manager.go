@@ -2,12 +2,22 @@ package plugins
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/metrics"
+	"github.com/CloudNativeGame/fake-time-injector/pkg/version"
 	"github.com/CloudNativeGame/fake-time-injector/plugins/faketime"
 	"github.com/CloudNativeGame/fake-time-injector/plugins/utils"
 	admissionV1 "k8s.io/api/admission/v1"
 	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	log "k8s.io/klog"
+	"sort"
+	"strings"
+)
+
+const (
+	InjectorVersion = "cloudnativegame.io/fake-time-injector-version"
 )
 
 var (
@@ -35,26 +45,178 @@ func (pm *PluginManager) register(plugin Plugin) (err error) {
 	return fmt.Errorf("plugin %v is invalid", plugin)
 }
 
-// handle patch pod operations
-func (pm *PluginManager) HandlePatchPod(pod *apiv1.Pod, operation admissionV1.Operation) ([]byte, error) {
+// handle patch pod operations, the results describe what every matched plugin did
+func (pm *PluginManager) HandlePatchPod(pod *apiv1.Pod, operation admissionV1.Operation) ([]byte, []utils.PatchResult, error) {
+	patchOperations, results := pm.patchOperations(pod, operation, []*apiv1.Pod{pod}, func(plugin Plugin) ([]utils.PatchOperation, error) {
+		return plugin.Patch(pod, operation)
+	})
+	if len(patchOperations) == 0 {
+		// no match any one
+		return nil, results, nil
+	}
+	patchBytes, err := json.Marshal(patchOperations)
+	if err != nil {
+		log.Warningf("Failed to marshal patch bytes by plugin skip,because of %v", err)
+		return nil, results, err
+	}
+	return patchBytes, results, nil
+}
+
+// HandlePatchPodTemplate patches the pod template of a workload, templatePath is the json pointer of the template in the workload, e.g. /spec/template
+func (pm *PluginManager) HandlePatchPodTemplate(template *apiv1.PodTemplateSpec, namespace string, templatePath string) ([]byte, []utils.PatchResult, error) {
+	pod := &apiv1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: utils.PodTemplateKind},
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = namespace
+	}
+	// the template is rendered like a pod being created whenever the workload is written
+	patchOperations, results := pm.patchOperations(pod, admissionV1.Create, []*apiv1.Pod{pod}, func(plugin Plugin) ([]utils.PatchOperation, error) {
+		return plugin.Patch(pod, admissionV1.Create)
+	})
+	if len(patchOperations) == 0 {
+		return nil, results, nil
+	}
+	for i := range patchOperations {
+		patchOperations[i].Path = templatePath + patchOperations[i].Path
+	}
+	patchBytes, err := json.Marshal(patchOperations)
+	if err != nil {
+		log.Warningf("Failed to marshal patch bytes of pod template,because of %v", err)
+		return nil, results, err
+	}
+	return patchBytes, results, nil
+}
+
+// HandleUpdatePod patches a running pod in place by the plugins implementing Updater
+func (pm *PluginManager) HandleUpdatePod(oldPod *apiv1.Pod, pod *apiv1.Pod) ([]byte, []utils.PatchResult, error) {
+	// a plugin removed from the pod may have to undo its change
+	patchOperations, results := pm.patchOperations(pod, admissionV1.Update, []*apiv1.Pod{oldPod, pod}, func(plugin Plugin) ([]utils.PatchOperation, error) {
+		updater, ok := plugin.(Updater)
+		if !ok {
+			return nil, nil
+		}
+		return updater.PatchUpdate(oldPod, pod)
+	})
+	if len(patchOperations) == 0 {
+		return nil, results, nil
+	}
+	patchBytes, err := json.Marshal(patchOperations)
+	if err != nil {
+		log.Warningf("Failed to marshal patch bytes of pod update,because of %v", err)
+		return nil, results, err
+	}
+	return patchBytes, results, nil
+}
+
+// patchOperations collects the patches of all plugins matching any of the matched pods
+func (pm *PluginManager) patchOperations(pod *apiv1.Pod, operation admissionV1.Operation, matched []*apiv1.Pod, patch func(Plugin) ([]utils.PatchOperation, error)) ([]utils.PatchOperation, []utils.PatchResult) {
 	patchOperations := make([]utils.PatchOperation, 0)
+	results := make([]utils.PatchResult, 0)
 	for _, plugin := range pm.plugins {
-		if plugin.MatchAnnotations(pod.Annotations) {
-			singlePatchOperations := plugin.Patch(pod, operation)
+		if matchAny(plugin, matched) {
+			metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginMatched).Inc()
+			singlePatchOperations, err := patch(plugin)
+			if errors.Is(err, utils.ErrRestartRequired) {
+				metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginRestartRequired).Inc()
+				log.Warningf("Plugin %s can not patch pod %s/%s in place,because of %v", plugin.Name(), pod.Namespace, pod.Name, err)
+				results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonRestartRequired, Message: err.Error()})
+				continue
+			}
+			if errors.Is(err, utils.ErrPolicyViolation) {
+				metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginPolicyViolation).Inc()
+				log.Warningf("Plugin %s did not patch pod %s/%s,because of %v", plugin.Name(), pod.Namespace, pod.Name, err)
+				results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonPolicyViolation, Message: err.Error()})
+				continue
+			}
+			if errors.Is(err, utils.ErrPolicyWarning) {
+				metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginPolicyWarning).Inc()
+				results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonPolicyWarning,
+					Message: fmt.Sprintf("Injected by %s with %d patch operations despite %v", plugin.Name(), len(singlePatchOperations), err)})
+				patchOperations = append(patchOperations, singlePatchOperations...)
+				continue
+			}
+			if err != nil {
+				metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginInvalid).Inc()
+				log.Errorf("Plugin %s failed to patch pod %s/%s,because of %v", plugin.Name(), pod.Namespace, pod.Name, err)
+				results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonInvalidSpec, Message: err.Error()})
+				continue
+			}
+			if len(singlePatchOperations) == 0 {
+				if operation == admissionV1.Update {
+					// most updates do not change the fake time, they are not worth an event
+					continue
+				}
+				metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginSkipped).Inc()
+				results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonSkipped,
+					Message: fmt.Sprintf("No patch is required for %s operation", operation)})
+				continue
+			}
+			metrics.PluginEvents.WithLabelValues(plugin.Name(), metrics.PluginInjected).Inc()
+			results = append(results, utils.PatchResult{Plugin: plugin.Name(), Reason: utils.ReasonInjected,
+				Message: fmt.Sprintf("Injected by %s with %d patch operations", plugin.Name(), len(singlePatchOperations))})
 			patchOperations = append(patchOperations, singlePatchOperations...)
 		}
 	}
 	if len(patchOperations) > 0 {
-		patchBytes, err := json.Marshal(patchOperations)
-		if err != nil {
-			log.Warningf("Failed to marshal patch bytes by plugin skip,because of %v", err)
-		} else {
-			return patchBytes, nil
+		// record which injector mutated the pod
+		patchOperations = append(patchOperations, utils.PatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + utils.EscapeJSONPointer(InjectorVersion),
+			Value: version.Version,
+		})
+	}
+	return patchOperations, results
+}
+
+func matchAny(plugin Plugin, pods []*apiv1.Pod) bool {
+	for _, pod := range pods {
+		if plugin.MatchAnnotations(pod.Annotations) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePod returns the issues of the annotations of a pod or a pod template, including the unknown annotations of the injector
+func (pm *PluginManager) ValidatePod(pod *apiv1.Pod) []utils.ValidationIssue {
+	known := map[string]bool{InjectorVersion: true}
+	issues := make([]utils.ValidationIssue, 0)
+	for _, plugin := range pm.plugins {
+		validator, ok := plugin.(Validator)
+		if !ok {
+			continue
+		}
+		for _, key := range validator.Annotations() {
+			known[key] = true
+		}
+		issues = append(issues, validator.Validate(pod)...)
+	}
+	for key := range pod.Annotations {
+		if strings.HasPrefix(key, utils.AnnotationPrefix) && !known[key] {
+			issues = append(issues, utils.ValidationIssue{Annotation: key, Severity: utils.SeverityWarning, Message: "unknown annotation"})
 		}
 	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Annotation < issues[j].Annotation
+	})
+	return issues
+}
 
-	// no match any one
-	return nil, nil
+// Configured returns true if any plugin is registered
+func (pm *PluginManager) Configured() bool {
+	return len(pm.plugins) > 0
+}
+
+// Stop the background work of all plugins
+func (pm *PluginManager) Stop() {
+	for _, plugin := range pm.plugins {
+		if stopper, ok := plugin.(Stopper); ok {
+			stopper.Stop()
+		}
+	}
 }
 
 // return singleton
@@ -1,8 +1,73 @@
 package utils
 
+import (
+	"errors"
+	apiv1 "k8s.io/api/core/v1"
+	"strings"
+)
+
 // patchOperation represents a RFC6902 JSON patch operation.
 type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value,omitempty"`
 }
+
+const (
+	// ReasonInjected means the plugin patched the pod
+	ReasonInjected = "FakeTimeInjected"
+	// ReasonInvalidSpec means the pod matched the plugin but its spec can not be patched
+	ReasonInvalidSpec = "FakeTimeInvalidSpec"
+	// ReasonSkipped means the pod matched the plugin but no patch is required
+	ReasonSkipped = "FakeTimeSkipped"
+	// ReasonRestartRequired means the change of a running pod can not be applied in place
+	ReasonRestartRequired = "FakeTimeRestartRequired"
+	// ReasonPolicyViolation means the pod violates the safety policy and is not patched
+	ReasonPolicyViolation = "FakeTimePolicyViolation"
+	// ReasonPolicyWarning means the pod violates the safety policy but is patched anyway
+	ReasonPolicyWarning = "FakeTimePolicyWarning"
+)
+
+var (
+	// ErrRestartRequired is wrapped by the plugins when the change of a running pod can not be applied in place
+	ErrRestartRequired = errors.New("restart required")
+	// ErrPolicyViolation is wrapped by the plugins when the safety policy forbids patching the pod
+	ErrPolicyViolation = errors.New("policy violation")
+	// ErrPolicyWarning is wrapped by the plugins returning patches of a pod violating the safety policy in warn-only mode
+	ErrPolicyWarning = errors.New("policy warning")
+)
+
+// PodTemplateKind is the kind of the pods rendered from the pod template of a workload
+const PodTemplateKind = "PodTemplate"
+
+// IsPodTemplate returns true if the pod is rendered from a workload template, the template is
+// admitted long before its pods start, so nothing depending on the time of admission is written into it
+func IsPodTemplate(pod *apiv1.Pod) bool {
+	return pod.Kind == PodTemplateKind
+}
+
+// PatchResult is the outcome of a plugin which matched the pod
+type PatchResult struct {
+	Plugin  string
+	Reason  string
+	Message string
+}
+
+// EscapeJSONPointer escapes a map key to be used in the path of a json patch (RFC6901)
+func EscapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+const (
+	// AnnotationPrefix is the prefix of the annotations read and written by the injector
+	AnnotationPrefix = "cloudnativegame.io/"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+)
+
+// ValidationIssue is a problem of an annotation found by a plugin
+type ValidationIssue struct {
+	Annotation string `json:"annotation"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+}